@@ -0,0 +1,112 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Adaptive retunes a limiter's default rate from a health signal, cutting
+// it while a backend reports overload and growing it back once healthy:
+// additive increase, multiplicative decrease.
+type Adaptive[K comparable] struct {
+	rl         *KeyedRateLimiter[K]
+	overloaded func() bool
+	o          adaptiveOptions
+
+	done      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+}
+
+// AdaptiveOption configures an Adaptive created by NewAdaptive.
+type AdaptiveOption func(*adaptiveOptions)
+
+type adaptiveOptions struct {
+	min, max rate.Limit
+	increase rate.Limit
+	decrease float64
+}
+
+// WithRateBounds keeps the rate an Adaptive sets within [min, max]. By
+// default it ranges from 0 to the limiter's rate when NewAdaptive is
+// called.
+func WithRateBounds(min, max rate.Limit) AdaptiveOption {
+	return func(o *adaptiveOptions) {
+		o.min, o.max = min, max
+	}
+}
+
+// WithRateSteps sets how an Adaptive changes the rate: it adds increase
+// when healthy and multiplies by decrease, between 0 and 1, when
+// overloaded. The defaults are a tenth of the maximum rate and 0.5.
+func WithRateSteps(increase rate.Limit, decrease float64) AdaptiveOption {
+	return func(o *adaptiveOptions) {
+		o.increase, o.decrease = increase, decrease
+	}
+}
+
+// NewAdaptive starts adjusting rl's default rate every interval, asking
+// overloaded whether the backend is struggling. Keys with an override
+// aren't affected, and the burst is left alone. A zero interval doesn't
+// start the goroutine, leaving the caller to call Adjust. Call Close to
+// stop adjusting.
+func NewAdaptive[K comparable](rl *KeyedRateLimiter[K], interval time.Duration, overloaded func() bool, opts ...AdaptiveOption) *Adaptive[K] {
+	o := adaptiveOptions{max: rl.Rate(), decrease: 0.5}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.increase == 0 {
+		o.increase = o.max / 10
+	}
+
+	a := &Adaptive[K]{
+		rl:         rl,
+		overloaded: overloaded,
+		o:          o,
+		done:       make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+	if interval > 0 {
+		go a.run(interval)
+	} else {
+		close(a.exited)
+	}
+	return a
+}
+
+func (a *Adaptive[K]) run(interval time.Duration) {
+	defer close(a.exited)
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-a.rl.clock.After(interval):
+			a.Adjust()
+		}
+	}
+}
+
+// Adjust makes one adjustment, as the background goroutine does every
+// interval, and returns the new rate.
+func (a *Adaptive[K]) Adjust() rate.Limit {
+	r := a.rl.Rate()
+	if a.overloaded() {
+		r = rate.Limit(float64(r) * a.o.decrease)
+	} else {
+		r += a.o.increase
+	}
+	r = min(max(r, a.o.min), a.o.max)
+	a.rl.SetDefaults(r, a.rl.Burst())
+	return r
+}
+
+// Close stops the background goroutine and waits for it to exit, leaving
+// the rate where it is. Calling Close more than once is a no-op.
+func (a *Adaptive[K]) Close() {
+	a.closeOnce.Do(func() {
+		close(a.done)
+	})
+	<-a.exited
+}
@@ -0,0 +1,33 @@
+package ratelimiter
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestAdaptive(t *testing.T) {
+	rl := New(time.Minute, 100, 10)
+	defer rl.Close()
+	signal := []bool{true, true, true, false, false, true, false, false, false, false, false, false, false, false, false}
+	a := NewAdaptive(rl, 0, func() bool {
+		overloaded := signal[0]
+		signal = signal[1:]
+		return overloaded
+	}, WithRateBounds(20, 100), WithRateSteps(10, 0.5))
+	defer a.Close()
+
+	var got []rate.Limit
+	for range len(signal) {
+		got = append(got, a.Adjust())
+	}
+	want := []rate.Limit{50, 25, 20, 30, 40, 20, 30, 40, 50, 60, 70, 80, 90, 100, 100}
+	if !slices.Equal(got, want) {
+		t.Errorf("rates = %v, want %v", got, want)
+	}
+	if rl.Rate() != 100 || rl.Burst() != 10 {
+		t.Errorf("limiter left at %v and %d, want 100 and 10", rl.Rate(), rl.Burst())
+	}
+}
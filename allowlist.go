@@ -0,0 +1,135 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// allowlist holds the keys and networks that bypass limiting.
+type allowlist[K comparable] struct {
+	mu       sync.RWMutex
+	keys     map[K]struct{}
+	prefixes []netip.Prefix
+	active   atomic.Bool // Whether keys or prefixes hold anything, so lookups can be skipped.
+}
+
+// AllowKeys adds keys to the limiter's allowlist, for clients such as
+// health checkers and internal services that must never be throttled.
+// Every method that decides a request, from Limit and its variants, Check,
+// AllowUpTo, Admit and Peek to Wait and its variants, allows requests for
+// allowlisted keys straight away, without creating an entry, taking tokens
+// or telling observers, so they aren't counted by WithStats either, and
+// Commit doesn't charge them. LimitHierarchical lets an allowlisted child
+// through and limits the children of an allowlisted parent by their own
+// buckets only. Reserve and ReserveN, which return a reservation on the
+// key's bucket, still treat them like any other key.
+func (rl *KeyedRateLimiter[K]) AllowKeys(keys ...K) {
+	a := &rl.allow
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.keys == nil {
+		a.keys = make(map[K]struct{}, len(keys))
+	}
+	for _, k := range keys {
+		a.keys[rl.keyOf(k)] = struct{}{}
+	}
+	a.active.Store(len(a.keys) > 0 || len(a.prefixes) > 0)
+}
+
+// DisallowKeys removes keys from the allowlist, so they are limited again.
+func (rl *KeyedRateLimiter[K]) DisallowKeys(keys ...K) {
+	a := &rl.allow
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, k := range keys {
+		delete(a.keys, rl.keyOf(k))
+	}
+	a.active.Store(len(a.keys) > 0 || len(a.prefixes) > 0)
+}
+
+// AllowPrefix adds a network, such as 10.0.0.0/8, to the allowlist. Keys
+// that are IP addresses in the network, or subnets in CIDR notation within
+// it as produced by RemoteCIDR, are then allowed as AllowKeys describes,
+// and so are requests from clients in the network passed through the
+// limiter's Middleware, whatever key its keyFunc derives. Only string-keyed
+// limiters match keys against networks.
+func (rl *KeyedRateLimiter[K]) AllowPrefix(p netip.Prefix) {
+	p = p.Masked()
+	a := &rl.allow
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !slices.Contains(a.prefixes, p) {
+		a.prefixes = append(a.prefixes, p)
+	}
+	a.active.Store(true)
+}
+
+// DisallowPrefix removes a network added with AllowPrefix.
+func (rl *KeyedRateLimiter[K]) DisallowPrefix(p netip.Prefix) {
+	p = p.Masked()
+	a := &rl.allow
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prefixes = slices.DeleteFunc(a.prefixes, func(q netip.Prefix) bool { return q == p })
+	a.active.Store(len(a.keys) > 0 || len(a.prefixes) > 0)
+}
+
+// allowlisted reports whether k, already mapped by keyOf, is on the
+// allowlist.
+func (rl *KeyedRateLimiter[K]) allowlisted(k K) bool {
+	a := &rl.allow
+	if !a.active.Load() {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if _, ok := a.keys[k]; ok {
+		return true
+	}
+	if len(a.prefixes) == 0 {
+		return false
+	}
+	s, ok := any(k).(string)
+	if !ok {
+		return false
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return a.containsLocked(addr.Unmap(), addr.Unmap().BitLen())
+	}
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return a.containsLocked(p.Addr().Unmap(), p.Bits())
+	}
+	return false
+}
+
+// allowlistedRequest reports whether r comes from a client in a network
+// added with AllowPrefix.
+func (rl *KeyedRateLimiter[K]) allowlistedRequest(r *http.Request) bool {
+	a := &rl.allow
+	if !a.active.Load() {
+		return false
+	}
+	addr, err := netip.ParseAddr(RemoteIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.containsLocked(addr, addr.BitLen())
+}
+
+// containsLocked reports whether the network of addr with a prefix of
+// bits lies within one of the allowlisted networks. The caller must hold
+// a.mu.
+func (a *allowlist[K]) containsLocked(addr netip.Addr, bits int) bool {
+	for _, p := range a.prefixes {
+		if p.Bits() <= bits && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
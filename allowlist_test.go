@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAllowKeys(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.AllowKeys("trusted")
+	for i := range 10 {
+		if rl.Limit("trusted") {
+			t.Fatalf("request %d for an allowlisted key limited", i)
+		}
+	}
+	if rl.Limit("other") || !rl.Limit("other") {
+		t.Error("key not on the allowlist not throttled")
+	}
+	if rl.Count() != 1 {
+		t.Error("the allowlisted key was given an entry")
+	}
+	rl.DisallowKeys("trusted")
+	if rl.Limit("trusted") || !rl.Limit("trusted") {
+		t.Error("key removed from the allowlist not throttled")
+	}
+}
+
+func TestAllowKeysEveryMethod(t *testing.T) {
+	methods := map[string]func(rl *RateLimiter, k string) bool{
+		"Limit":             func(rl *RateLimiter, k string) bool { return rl.Limit(k) },
+		"LimitN":            func(rl *RateLimiter, k string) bool { return rl.LimitN(k, 1) },
+		"Check":             func(rl *RateLimiter, k string) bool { return !rl.Check(k).Allowed },
+		"LimitWithPenalty":  func(rl *RateLimiter, k string) bool { return rl.LimitWithPenalty(k, 1) },
+		"LimitMany":         func(rl *RateLimiter, k string) bool { return rl.LimitMany([]string{k})[k] },
+		"LimitAll":          func(rl *RateLimiter, k string) bool { return rl.LimitAll([]string{k}) },
+		"AllowUpTo":         func(rl *RateLimiter, k string) bool { return rl.AllowUpTo(k, 1) == 0 },
+		"LimitHierarchical": func(rl *RateLimiter, k string) bool { return rl.LimitHierarchical(k, "parent") },
+		"LimitPriority":     func(rl *RateLimiter, k string) bool { return rl.LimitPriority(k, PriorityNormal) },
+		"Admit": func(rl *RateLimiter, k string) bool {
+			_, ok := rl.Admit(k)
+			return !ok
+		},
+		"Peek": func(rl *RateLimiter, k string) bool {
+			ok := rl.Peek(k)
+			rl.Commit(k)
+			return !ok
+		},
+		"WaitUntilAllowed": func(rl *RateLimiter, k string) bool {
+			return rl.WaitUntilAllowed(context.Background(), k, 0) != nil
+		},
+	}
+	for name, limit := range methods {
+		rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+		rl.AllowKeys("trusted")
+		for i := range 3 {
+			if limit(rl, "trusted") {
+				t.Errorf("%s: request %d for an allowlisted key limited", name, i)
+			}
+		}
+		if got := rl.Count(); got != 0 {
+			t.Errorf("%s: %d entries created for an allowlisted key", name, got)
+		}
+		rl.Close()
+	}
+
+	sl := NewStrategy(time.Minute, FixedWindow(1, time.Minute), WithClock(newFakeClock()))
+	defer sl.Close()
+	sl.rl.AllowKeys("trusted")
+	if sl.Limit("trusted") || sl.Limit("trusted") {
+		t.Error("StrategyLimiter limited an allowlisted key")
+	}
+}
+
+func TestAllowlistedParent(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.AllowKeys("parent")
+	if rl.LimitHierarchical("a", "parent") || rl.LimitHierarchical("b", "parent") {
+		t.Error("children of an allowlisted parent limited with tokens of their own")
+	}
+	if !rl.LimitHierarchical("a", "parent") {
+		t.Error("child of an allowlisted parent allowed past its own limit")
+	}
+	if rl.Count() != 2 {
+		t.Error("the allowlisted parent was given an entry")
+	}
+}
+
+func TestAllowPrefix(t *testing.T) {
+	rl := New(time.Minute, 0, 0)
+	defer rl.Close()
+
+	rl.AllowPrefix(netip.MustParsePrefix("10.0.0.0/8"))
+	rl.AllowPrefix(netip.MustParsePrefix("2001:db8::/32"))
+	for k, want := range map[string]bool{"10.1.2.3": false, "2001:db8::1": false, "192.0.2.1": true, "not an ip": true} {
+		if got := rl.Limit(k); got != want {
+			t.Errorf("Limit(%q) = %v, want %v", k, got, want)
+		}
+	}
+	rl.DisallowPrefix(netip.MustParsePrefix("10.0.0.0/8"))
+	if !rl.Limit("10.1.2.3") {
+		t.Error("address in a removed prefix allowed")
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func BenchmarkLimitWarmKey(b *testing.B) {
+	rl := New(time.Minute, 1e9, 1e9)
+	defer rl.Close()
+	rl.Limit("k")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		rl.Limit("k")
+	}
+}
+
+func BenchmarkLimitWarmKeyParallel(b *testing.B) {
+	rl := New(time.Minute, 1e9, 1e9)
+	defer rl.Close()
+	rl.Limit("k")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rl.Limit("k")
+		}
+	})
+}
+
+func TestLimitWarmKeyAllocs(t *testing.T) {
+	rl := New(time.Minute, 1e9, 1e9)
+	defer rl.Close()
+	rl.Limit("k")
+
+	if n := testing.AllocsPerRun(1000, func() { rl.Limit("k") }); n != 0 {
+		t.Errorf("Limit on a warm key allocates %v times, want 0", n)
+	}
+}
+
+// BenchmarkLimitHotKeyParallel hammers one key from every core, where the
+// write to the entry's last seen time on each request contends between
+// them unless WithSeenCoalescing or WithSeenGranularity skips it.
+func BenchmarkLimitHotKeyParallel(b *testing.B) {
+	for name, opts := range map[string][]Option{
+		"EveryRequest":        nil,
+		"WithSeenCoalescing":  {WithSeenCoalescing(time.Second)},
+		"WithSeenGranularity": {WithSeenGranularity(time.Second)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			rl := New(time.Minute, 1e9, 1e9, opts...)
+			defer rl.Close()
+			rl.Limit("k")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rl.Limit("k")
+				}
+			})
+		})
+	}
+}
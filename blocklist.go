@@ -0,0 +1,153 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// blocklist holds the keys that are always limited, with when each block
+// ends, or the zero time for blocks that don't.
+type blocklist[K comparable] struct {
+	mu     sync.RWMutex
+	keys   map[K]time.Time
+	active atomic.Bool // Whether keys holds anything, so lookups can be skipped.
+}
+
+// BlockKey adds k to the limiter's blocklist, for keys known to be
+// abusive: until the block ends, every request for k is limited, whatever
+// its bucket holds and without taking tokens. A duration of zero or less
+// blocks k until UnblockKey is called; otherwise the block ends on its own
+// after d. Blocking a key again replaces its previous block. A key that is
+// both blocked and allowlisted is limited.
+func (rl *KeyedRateLimiter[K]) BlockKey(k K, d time.Duration) {
+	var until time.Time
+	if d > 0 {
+		until = rl.clock.Now().Add(d)
+	}
+	b := &rl.block
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keys == nil {
+		b.keys = make(map[K]time.Time)
+	}
+	b.keys[rl.keyOf(k)] = until
+	b.active.Store(true)
+}
+
+// LoadBlocklist blocks every key listed in r, one per line, until
+// UnblockKey is called, for feeding in lists of known-bad keys or IPs.
+// Blank lines and lines starting with # are ignored, as is anything after
+// a # that follows a key. Lines holding more than one word are malformed:
+// they are skipped and reported in the returned error, joined, while the
+// valid keys are still blocked, together, after r has been read. It
+// returns the number of keys added to the blocklist, leaving out repeated
+// lines and keys that were already blocked, whose blocks are made
+// permanent. It requires string keys and returns an error otherwise.
+func (rl *KeyedRateLimiter[K]) LoadBlocklist(r io.Reader) (int, error) {
+	var keys []K
+	var errs []error
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(text)
+		switch {
+		case len(fields) == 0:
+			continue
+		case len(fields) > 1:
+			errs = append(errs, fmt.Errorf("line %d: more than one key in %q", line, strings.TrimSpace(text)))
+			continue
+		}
+		k, ok := any(fields[0]).(K)
+		if !ok {
+			return 0, errors.New("ratelimiter: LoadBlocklist needs string keys")
+		}
+		keys = append(keys, rl.keyOf(k))
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	b := &rl.block
+	b.mu.Lock()
+	if b.keys == nil {
+		b.keys = make(map[K]time.Time, len(keys))
+	}
+	added := 0
+	now := rl.clock.Now()
+	for _, k := range keys {
+		// Blocks that have ended but not yet been expired count as gone.
+		if until, ok := b.keys[k]; !ok || !until.IsZero() && !now.Before(until) {
+			added++
+		}
+		b.keys[k] = time.Time{}
+	}
+	b.active.Store(len(b.keys) > 0)
+	b.mu.Unlock()
+	return added, errors.Join(errs...)
+}
+
+// UnblockKey removes k from the blocklist before its block ends.
+func (rl *KeyedRateLimiter[K]) UnblockKey(k K) {
+	b := &rl.block
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.keys, rl.keyOf(k))
+	b.active.Store(len(b.keys) > 0)
+}
+
+// blocked reports how long k, already mapped by keyOf, remains on the
+// blocklist at now, and whether it is on it at all. The duration is 0 for
+// a block without an end.
+func (rl *KeyedRateLimiter[K]) blocked(k K, now time.Time) (time.Duration, bool) {
+	b := &rl.block
+	if !b.active.Load() {
+		return 0, false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	until, ok := b.keys[k]
+	switch {
+	case !ok:
+		return 0, false
+	case until.IsZero():
+		return 0, true
+	case now.Before(until):
+		return until.Sub(now), true
+	}
+	return 0, false
+}
+
+// expireBlocks removes the blocks that have ended by now.
+func (rl *KeyedRateLimiter[K]) expireBlocks(now time.Time) {
+	b := &rl.block
+	if !b.active.Load() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k, until := range b.keys {
+		if !until.IsZero() && !now.Before(until) {
+			delete(b.keys, k)
+		}
+	}
+	b.active.Store(len(b.keys) > 0)
+}
+
+// listed reports whether a request for k, already mapped by keyOf, is
+// decided at now by the blocklist or allowlist alone, and if so whether
+// it is limited.
+func (rl *KeyedRateLimiter[K]) listed(k K, now time.Time) (limited, decided bool) {
+	if _, ok := rl.blocked(k, now); ok {
+		return true, true
+	}
+	if rl.allowlisted(k) {
+		return false, true
+	}
+	return false, false
+}
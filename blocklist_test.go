@@ -0,0 +1,107 @@
+package ratelimiter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockKey(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 5, WithClock(clock))
+	defer rl.Close()
+
+	rl.BlockKey("k", time.Second)
+	rl.AllowKeys("k")
+	if !rl.Limit("k") {
+		t.Error("blocked key not limited")
+	}
+	clock.Advance(time.Second)
+	if rl.Limit("k") {
+		t.Error("key still limited once its block ended")
+	}
+
+	rl.BlockKey("k", 0)
+	clock.Advance(time.Hour)
+	if !rl.Limit("k") {
+		t.Error("block without an end ended")
+	}
+	rl.UnblockKey("k")
+	if rl.Limit("k") {
+		t.Error("key limited after UnblockKey")
+	}
+}
+
+func TestBlockKeyEveryMethod(t *testing.T) {
+	rl := New(time.Minute, 1, 5, WithClock(newFakeClock()))
+	defer rl.Close()
+	rl.Limit("k")
+	rl.BlockKey("k", 0)
+
+	methods := map[string]func() bool{
+		"Limit":             func() bool { return rl.Limit("k") },
+		"LimitN":            func() bool { return rl.LimitN("k", 2) },
+		"LimitWithPenalty":  func() bool { return rl.LimitWithPenalty("k", 1) },
+		"LimitMany":         func() bool { return rl.LimitMany([]string{"k"})["k"] },
+		"AllowUpTo":         func() bool { return rl.AllowUpTo("k", 3) == 0 },
+		"LimitHierarchical": func() bool { return rl.LimitHierarchical("k", "parent") },
+		"Check":             func() bool { return !rl.Check("k").Allowed },
+		"LimitSoft":         func() bool { return rl.LimitSoft("k", 0.5) == Denied },
+		"LimitPriority":     func() bool { return rl.LimitPriority("k", PriorityHigh) },
+		"Admit": func() bool {
+			_, ok := rl.Admit("k")
+			return !ok
+		},
+	}
+	for name, limit := range methods {
+		if !limit() {
+			t.Errorf("%s allowed a blocked key", name)
+		}
+	}
+	if got := rl.Remaining("k", RoundDown); got != 4 {
+		t.Errorf("blocked key has %d tokens left, want 4", got)
+	}
+}
+
+func TestBlockParent(t *testing.T) {
+	rl := New(time.Minute, 1, 5, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.BlockKey("user", 0)
+	if !rl.LimitHierarchical("key", "user") {
+		t.Error("child of a blocked parent allowed")
+	}
+	if got := rl.Remaining("key", RoundDown); got != 5 {
+		t.Errorf("child charged %d tokens, want none", 5-got)
+	}
+}
+
+func TestLoadBlocklist(t *testing.T) {
+	rl := New(time.Minute, 1, 5)
+	defer rl.Close()
+
+	n, err := rl.LoadBlocklist(strings.NewReader("# bad actors\na\n\nb # scraper\nc d\n"))
+	if n != 2 {
+		t.Errorf("LoadBlocklist blocked %d keys, want 2", n)
+	}
+	if err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Errorf("LoadBlocklist error = %v, want one for line 5", err)
+	}
+	for k, want := range map[string]bool{"a": true, "b": true, "c": false, "d": false} {
+		if got := rl.Limit(k); got != want {
+			t.Errorf("Limit(%q) = %v, want %v", k, got, want)
+		}
+	}
+
+	// Keys repeated or already blocked don't count as added.
+	rl.BlockKey("e", time.Minute)
+	if n, err := rl.LoadBlocklist(strings.NewReader("a\ne\nf\nf\n")); n != 1 || err != nil {
+		t.Errorf("LoadBlocklist of one new key = %d, %v, want 1, nil", n, err)
+	}
+
+	ints := NewKeyed[int](time.Minute, 1, 5)
+	defer ints.Close()
+	if _, err := ints.LoadBlocklist(strings.NewReader("1\n")); err == nil {
+		t.Error("LoadBlocklist accepted int keys")
+	}
+}
@@ -0,0 +1,103 @@
+package ratelimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// boost is the state of the temporary multiplier set with BoostAll.
+type boost struct {
+	mu     sync.Mutex
+	gen    uint64      // Counts boosts, so a stale timer doesn't end a newer one.
+	factor float64     // The current multiplier, 0 when no boost is active.
+	timer  *time.Timer // Ends the current boost.
+}
+
+// BoostAll multiplies the rate and burst of every key, those with
+// overrides included, by factor for duration, for planned high-traffic
+// events, then reverts them automatically. Existing entries are changed
+// straight away, keeping their tokens up to the new burst, and entries
+// created during the boost start boosted. Bursts are rounded to the
+// nearest whole token. Boosts don't compose: a call while one is active
+// replaces it, so the latest factor and duration win, and a factor or
+// duration of zero or less just ends the active boost. When a boost ends,
+// every entry returns to its override or the defaults, as SetDefaults
+// would set them, dropping changes made with SetKeyBurst or SetKeyRate.
+// Rate and Burst report the limits without the boost. The duration is
+// measured in real time, even with WithClock.
+func (rl *KeyedRateLimiter[K]) BoostAll(factor float64, duration time.Duration) {
+	b := &rl.boost
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.gen++
+	if !(factor > 0) || duration <= 0 {
+		factor = 0
+	}
+	rl.setBoost(factor)
+	if factor == 0 {
+		return
+	}
+
+	gen := b.gen
+	b.timer = time.AfterFunc(duration, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.gen == gen {
+			b.timer = nil
+			rl.setBoost(0)
+		}
+	})
+}
+
+// setBoost makes factor the multiplier, 0 for none, and applies it to
+// every entry. The caller must hold rl.boost.mu.
+func (rl *KeyedRateLimiter[K]) setBoost(factor float64) {
+	rl.boost.factor = factor
+	rl.boosted.Store(math.Float64bits(factor))
+
+	// Hold defaultsMu so that SetDefaults can't apply stale limits
+	// during the walk over the shards.
+	rl.defaultsMu.Lock()
+	defer rl.defaultsMu.Unlock()
+	now := rl.clock.Now()
+	d := *rl.defaults.Load()
+	for _, sh := range rl.shards {
+		sh.mu.Lock()
+		for k, v := range sh.entries {
+			if v.limiter == nil {
+				continue
+			}
+			lim := d
+			if o, ok := sh.overrides[k]; ok {
+				lim = o
+			} else if v.probation {
+				lim = rl.probation.limit
+			}
+			lim = rl.boosting(lim)
+			v.limiter.SetLimitAt(now, lim.rate)
+			v.limiter.SetBurstAt(now, lim.burst)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// boosting returns lim multiplied by the active BoostAll factor, if any.
+func (rl *KeyedRateLimiter[K]) boosting(lim keyLimit) keyLimit {
+	f := math.Float64frombits(rl.boosted.Load())
+	if f == 0 {
+		return lim
+	}
+	if lim.rate != rate.Inf {
+		lim.rate = rate.Limit(float64(lim.rate) * f)
+	}
+	lim.burst = int(math.Round(float64(lim.burst) * f))
+	return lim
+}
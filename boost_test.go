@@ -0,0 +1,42 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoostAll(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 2, WithClock(clock))
+	defer rl.Close()
+
+	allowed := func(k string) int {
+		n := 0
+		for !rl.Limit(k) {
+			n++
+		}
+		return n
+	}
+	rl.BoostAll(2, 50*time.Millisecond)
+	if got := allowed("k"); got != 4 {
+		t.Errorf("%d requests allowed during a 2x boost, want 4", got)
+	}
+	clock.Advance(time.Second)
+	if got := allowed("k"); got != 2 {
+		t.Errorf("%d requests allowed a second into a 2x boost, want 2", got)
+	}
+	if rl.Rate() != 1 || rl.Burst() != 2 {
+		t.Errorf("Rate and Burst during the boost = %v, %d, want the unboosted 1, 2", rl.Rate(), rl.Burst())
+	}
+
+	waitFor(t, "the boost to end", func() bool { return rl.Tokens("new") == 2 })
+	if got := allowed("new"); got != 2 {
+		t.Errorf("%d requests allowed after the boost, want 2", got)
+	}
+
+	rl.BoostAll(2, time.Hour)
+	rl.BoostAll(0, time.Hour)
+	if got := allowed("other"); got != 2 {
+		t.Errorf("%d requests allowed after ending a boost early, want 2", got)
+	}
+}
@@ -0,0 +1,20 @@
+package ratelimiter
+
+import "time"
+
+// Clock is the source of time used by a RateLimiter. The default reads the
+// wall clock; tests can pass their own implementation to WithClock to
+// advance time deterministically instead of sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel that receives once d has elapsed. It is
+	// used to wait between cleanup passes.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
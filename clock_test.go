@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// start is the time every fakeClock begins at.
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeClock is a Clock that only moves when Advance is called. Channels
+// returned by After fire once the clock has been advanced past them.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: start}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing any After channels that
+// are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+// waiting returns the number of After channels that haven't fired yet.
+func (c *fakeClock) waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// waitFor polls cond until it holds, failing t if it doesn't within a
+// second, for effects of a fake clock's Advance that happen on another
+// goroutine.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClockDrivesCleanup(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 1, WithExpiry(time.Minute), WithClock(clock))
+	defer rl.Close()
+
+	rl.Limit("k")
+	waitFor(t, "the cleanup goroutine to wait", func() bool { return clock.waiting() == 1 })
+	clock.Advance(time.Minute)
+	waitFor(t, "the cleanup goroutine to wait again", func() bool { return clock.waiting() == 1 })
+	if rl.Count() != 1 {
+		t.Fatal("entry removed before its expiry")
+	}
+	clock.Advance(time.Minute)
+	waitFor(t, "the entry to be removed", func() bool { return rl.Count() == 0 })
+}
+
+func TestSetCleanupInterval(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Hour, 1, 1, WithExpiry(time.Minute), WithClock(clock))
+	defer rl.Close()
+
+	rl.Limit("k")
+	waitFor(t, "the cleanup goroutine to wait", func() bool { return clock.waiting() == 1 })
+	rl.SetCleanupInterval(time.Minute)
+	// The abandoned hour-long wait stays registered with the clock.
+	waitFor(t, "the cleanup goroutine to wait anew", func() bool { return clock.waiting() == 2 })
+	clock.Advance(time.Minute + time.Second)
+	waitFor(t, "the entry to be removed", func() bool { return rl.Count() == 0 })
+}
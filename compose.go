@@ -0,0 +1,119 @@
+package ratelimiter
+
+// Rule combines checks on several keys, possibly of different limiters,
+// into one decision, such as limiting a request if either its IP or its
+// user is over their limit. Rules are built with On, All and Any.
+type Rule interface {
+	// Limit returns true if the request should be limited, taking tokens
+	// only from the buckets that admitted it.
+	Limit() bool
+
+	// take takes the tokens for the rule, returning what was taken so it
+	// can be reported, or handed back if an enclosing All fails.
+	take() ([]grant, bool)
+}
+
+// grant is a token taken by an On rule.
+type grant struct {
+	undo   func()
+	report func(limited bool)
+}
+
+// On returns a Rule checking k in rl, as Limit does.
+func On[K comparable](rl *KeyedRateLimiter[K], k K) Rule {
+	return onRule[K]{rl, rl.keyOf(k)}
+}
+
+// All returns a Rule that admits a request only if every rule does, and
+// then takes tokens from all of them; when any of them limits, the tokens
+// the others took are handed back. All with no rules admits everything.
+func All(rules ...Rule) Rule {
+	return allRule(rules)
+}
+
+// Any returns a Rule that admits a request if one of the rules does,
+// trying them in order and taking tokens only from the first that admits
+// it. Any with no rules limits everything.
+func Any(rules ...Rule) Rule {
+	return anyRule(rules)
+}
+
+// limitRule implements Limit for every Rule.
+func limitRule(r Rule) bool {
+	grants, ok := r.take()
+	for _, g := range grants {
+		g.report(false)
+	}
+	return !ok
+}
+
+type onRule[K comparable] struct {
+	rl *KeyedRateLimiter[K]
+	k  K
+}
+
+func (r onRule[K]) Limit() bool { return limitRule(r) }
+
+func (r onRule[K]) take() ([]grant, bool) {
+	rl, k := r.rl, r.k
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		// Listed keys are decided without an entry, and as with Limit
+		// the decision isn't reported.
+		if limited {
+			return nil, false
+		}
+		return []grant{{func() {}, func(bool) {}}}, true
+	}
+	limiter := rl.admitEntryAt(k, now)
+	if limiter == nil {
+		return nil, false
+	}
+	report := func(limited bool) { rl.report(k, limiter, now, limited) }
+
+	limited, decided := rl.preempt(k, now)
+	if decided && !limited {
+		return []grant{{func() {}, report}}, true
+	}
+	if limited || !rl.allowN(k, limiter, now, 1) {
+		report(true)
+		return nil, false
+	}
+	undo := func() { rl.refundAt(k, limiter, now, 1) }
+	return []grant{{undo, report}}, true
+}
+
+type allRule []Rule
+
+func (r allRule) Limit() bool { return limitRule(r) }
+
+func (r allRule) take() ([]grant, bool) {
+	var grants []grant
+	for _, rule := range r {
+		g, ok := rule.take()
+		if !ok {
+			// The request is limited after all, so hand back what the
+			// other rules took.
+			for _, g := range grants {
+				g.undo()
+				g.report(true)
+			}
+			return nil, false
+		}
+		grants = append(grants, g...)
+	}
+	return grants, true
+}
+
+type anyRule []Rule
+
+func (r anyRule) Limit() bool { return limitRule(r) }
+
+func (r anyRule) take() ([]grant, bool) {
+	for _, rule := range r {
+		if g, ok := rule.take(); ok {
+			return g, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,90 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestAllHandsBackTokens(t *testing.T) {
+	for _, r := range []rate.Limit{1, 0} {
+		clock := newFakeClock()
+		ip := New(time.Minute, 0, 1, WithClock(clock))
+		user := New(time.Minute, 1, 2, WithClock(clock))
+		ip.SetKeyRate("a", 0)
+		user.SetKeyRate("u", r)
+
+		if All(On(user, "u"), On(ip, "a")).Limit() {
+			t.Fatalf("rate %v: first request limited", r)
+		}
+		// ip is now empty, so user's token is handed back.
+		if !All(On(user, "u"), On(ip, "a")).Limit() {
+			t.Fatalf("rate %v: request past ip's burst allowed", r)
+		}
+		if got := user.Remaining("u", RoundDown); got != 1 {
+			t.Errorf("rate %v: user has %d tokens left, want 1", r, got)
+		}
+		if user.Limit("u") || !user.Limit("u") {
+			t.Errorf("rate %v: user's burst changed by the hand back", r)
+		}
+		ip.Close()
+		user.Close()
+	}
+}
+
+func TestAny(t *testing.T) {
+	clock := newFakeClock()
+	a := New(time.Minute, 1, 1, WithClock(clock))
+	b := New(time.Minute, 1, 1, WithClock(clock))
+	defer a.Close()
+	defer b.Close()
+
+	rule := Any(On(a, "k"), On(b, "k"))
+	for i, want := range []bool{false, false, true} {
+		if got := rule.Limit(); got != want {
+			t.Errorf("request %d limited = %v, want %v", i, got, want)
+		}
+	}
+	if Any().Limit() != true || All().Limit() != false {
+		t.Error("empty Any admits or empty All limits")
+	}
+}
+
+func TestAllAndAnyRules(t *testing.T) {
+	for _, tt := range []struct {
+		ip, user bool // Whether each dimension has a token left.
+		all, any bool // Whether All and Any limit the request.
+	}{
+		{true, true, false, false},
+		{true, false, true, false},
+		{false, true, true, false},
+		{false, false, true, true},
+	} {
+		clock := newFakeClock()
+		ip := New(time.Minute, 0, 1, WithClock(clock))
+		user := New(time.Minute, 0, 1, WithClock(clock))
+		if !tt.ip {
+			ip.Limit("a")
+		}
+		if !tt.user {
+			user.Limit("u")
+		}
+		if got := All(On(ip, "a"), On(user, "u")).Limit(); got != tt.all {
+			t.Errorf("ip %v, user %v: All limited = %v, want %v", tt.ip, tt.user, got, tt.all)
+		}
+		ip.Reset("a")
+		user.Reset("u")
+		if !tt.ip {
+			ip.Limit("a")
+		}
+		if !tt.user {
+			user.Limit("u")
+		}
+		if got := Any(On(ip, "a"), On(user, "u")).Limit(); got != tt.any {
+			t.Errorf("ip %v, user %v: Any limited = %v, want %v", tt.ip, tt.user, got, tt.any)
+		}
+		ip.Close()
+		user.Close()
+	}
+}
@@ -0,0 +1,100 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// creditPool holds the tokens a key has saved beyond its burst, set up by
+// SetKeyCredit. It has its own mutex because decisions are settled under
+// the shard's read lock.
+type creditPool struct {
+	mu    sync.Mutex
+	max   float64   // The most tokens that can be saved.
+	saved float64   // Tokens saved so far.
+	level float64   // The bucket's tokens after the previous decision,
+	at    time.Time // which was made then.
+}
+
+// SetKeyCredit lets k save up tokens its bucket would otherwise waste
+// when full, up to maxCredit beyond its burst, for customers whose prepaid
+// quota carries over idle periods. Requests that find the bucket empty
+// then spend the saved tokens, so after a long enough pause k can make
+// burst plus maxCredit requests in a row. Credit is earned and spent by
+// Limit, LimitN, LimitAt, TryLimit, LimitSoft and Admit. Like an override
+// the ceiling survives cleanup and is dropped by RemoveEntry, but the
+// credit saved is lost with the entry. A maxCredit of 0 or less stops k
+// saving credit.
+func (rl *KeyedRateLimiter[K]) SetKeyCredit(k K, maxCredit int) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if maxCredit <= 0 {
+		delete(sh.credits, k)
+		if v, exists := sh.entries[k]; exists {
+			v.credit = nil
+		}
+		return
+	}
+
+	if sh.credits == nil {
+		sh.credits = make(map[K]int)
+	}
+	sh.credits[k] = maxCredit
+	rl.credited.Store(true)
+
+	now := rl.clock.Now()
+	v := rl.entryLocked(sh, k, now)
+	if v.credit == nil {
+		v.credit = &creditPool{level: tokensAt(v.limiter, now), at: now}
+	}
+	v.credit.mu.Lock()
+	v.credit.max = float64(maxCredit)
+	v.credit.saved = min(v.credit.saved, v.credit.max)
+	v.credit.mu.Unlock()
+}
+
+// settleCredit updates k's credit after a decision at now for n tokens
+// that allowed says whether limiter granted, spending credit on the
+// request if it was refused. It returns whether the request is allowed.
+func (rl *KeyedRateLimiter[K]) settleCredit(k K, limiter *rate.Limiter, now time.Time, n int, allowed bool) bool {
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	v, exists := sh.entries[k]
+	var c *creditPool
+	if exists {
+		c = v.credit
+	}
+	sh.mu.RUnlock()
+	if c == nil {
+		return allowed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Credit is earned for the time the bucket spent full since the
+	// previous decision.
+	if r := float64(limiter.Limit()); r > 0 && now.After(c.at) {
+		full := (float64(limiter.Burst()) - c.level) / r
+		if idle := now.Sub(c.at).Seconds() - full; idle > 0 {
+			c.saved = min(c.saved+idle*r, c.max)
+		}
+	}
+	if !allowed && c.saved >= float64(n) {
+		if rl.global == nil {
+			allowed = true
+		} else {
+			_, allowed = takeNow(rl.global, now, n)
+		}
+		if allowed {
+			c.saved -= float64(n)
+		}
+	}
+	c.level, c.at = tokensAt(limiter, now), now
+	return allowed
+}
@@ -0,0 +1,31 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetKeyCredit(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 2, WithClock(clock))
+	defer rl.Close()
+	rl.SetKeyCredit("k", 3)
+
+	// Full from the start, the bucket saves a token a second.
+	clock.Advance(10 * time.Second)
+	for i := range 5 {
+		if rl.Limit("k") {
+			t.Fatalf("request %d within the burst and credit limited", i)
+		}
+	}
+	if !rl.Limit("k") {
+		t.Error("request past the burst and credit allowed")
+	}
+
+	rl.SetKeyCredit("k", 0)
+	clock.Advance(10 * time.Second)
+	rl.LimitN("k", 2)
+	if !rl.Limit("k") {
+		t.Error("request past the burst allowed once credit was turned off")
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// DecisionRecord is one decision kept by WithDecisionLog.
+type DecisionRecord[K comparable] struct {
+	Time      time.Time
+	Key       K
+	Allowed   bool
+	Remaining float64 // Tokens left in the key's bucket after the decision, 0 for a StrategyLimiter.
+}
+
+// decisionLog is a fixed size ring of the most recent decisions.
+type decisionLog[K comparable] struct {
+	mu      sync.Mutex
+	records []DecisionRecord[K]
+	next    int  // Where the next record goes.
+	full    bool // Whether records has wrapped around.
+}
+
+// add records d, overwriting the oldest record once the log is full.
+func (l *decisionLog[K]) add(d DecisionRecord[K]) {
+	l.mu.Lock()
+	l.records[l.next] = d
+	l.next++
+	if l.next == len(l.records) {
+		l.next, l.full = 0, true
+	}
+	l.mu.Unlock()
+}
+
+// each calls f for every record, oldest first. The caller must hold l.mu.
+func (l *decisionLog[K]) each(f func(d DecisionRecord[K])) {
+	if l.full {
+		for _, d := range l.records[l.next:] {
+			f(d)
+		}
+	}
+	for _, d := range l.records[:l.next] {
+		f(d)
+	}
+}
+
+// RecentDecisions returns the decisions made for k that are still in the
+// WithDecisionLog buffer, oldest first, for example to answer a customer
+// disputing their throttling. It returns nil without the option.
+func (rl *KeyedRateLimiter[K]) RecentDecisions(k K) []DecisionRecord[K] {
+	if rl.decisions == nil {
+		return nil
+	}
+	k = rl.keyOf(k)
+	l := rl.decisions
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ds []DecisionRecord[K]
+	l.each(func(d DecisionRecord[K]) {
+		if d.Key == k {
+			ds = append(ds, d)
+		}
+	})
+	return ds
+}
+
+// DecisionLog returns every decision in the WithDecisionLog buffer, oldest
+// first, or nil without the option.
+func (rl *KeyedRateLimiter[K]) DecisionLog() []DecisionRecord[K] {
+	if rl.decisions == nil {
+		return nil
+	}
+	l := rl.decisions
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ds := make([]DecisionRecord[K], 0, len(l.records))
+	l.each(func(d DecisionRecord[K]) {
+		ds = append(ds, d)
+	})
+	return ds
+}
@@ -0,0 +1,40 @@
+package ratelimiter
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDecisionLog(t *testing.T) {
+	clock := newFakeClock()
+	// Buckets that never refill make the second request for a key a denial.
+	rl := New(time.Minute, 0, 1, WithDecisionLog(3), WithClock(clock))
+	defer rl.Close()
+
+	for _, k := range []string{"a", "b", "a", "b"} {
+		rl.Limit(k)
+		clock.Advance(time.Second)
+	}
+	got := rl.DecisionLog()
+	want := []DecisionRecord[string]{
+		{start.Add(time.Second), "b", true, 0},
+		{start.Add(2 * time.Second), "a", false, 0},
+		{start.Add(3 * time.Second), "b", false, 0},
+	}
+	if !slices.EqualFunc(got, want, func(a, b DecisionRecord[string]) bool {
+		return a.Time.Equal(b.Time) && a.Key == b.Key && a.Allowed == b.Allowed && a.Remaining == b.Remaining
+	}) {
+		t.Errorf("DecisionLog = %+v, want the last 3 decisions %+v", got, want)
+	}
+	if got := rl.RecentDecisions("a"); len(got) != 1 || !got[0].Time.Equal(start.Add(2*time.Second)) {
+		t.Errorf("RecentDecisions(a) = %+v, want only the denial of a", got)
+	}
+
+	plain := New(time.Minute, 1, 1)
+	defer plain.Close()
+	plain.Limit("k")
+	if plain.DecisionLog() != nil || plain.RecentDecisions("k") != nil {
+		t.Error("decisions recorded without WithDecisionLog")
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimiter
+
+// DisableKey stops limiting k, for example to let a trusted partner
+// through during an incident, without forgetting its state: until
+// EnableKey is called every request for k is allowed without taking
+// tokens, while its entry, override and KeyStats counts are kept. The
+// flag survives cleanup of k's entry but is cleared by RemoveEntry.
+func (rl *KeyedRateLimiter[K]) DisableKey(k K) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, ok := sh.disabled[k]; ok {
+		return
+	}
+	if sh.disabled == nil {
+		sh.disabled = make(map[K]struct{})
+	}
+	sh.disabled[k] = struct{}{}
+	rl.disabledKeys.Add(1)
+}
+
+// EnableKey undoes DisableKey, so k is limited normally again, starting
+// from the tokens it had when it was disabled plus what it has earned
+// since.
+func (rl *KeyedRateLimiter[K]) EnableKey(k K) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	rl.enableLocked(sh, k)
+}
+
+// enableLocked clears k's DisableKey flag. The caller must hold sh.mu.
+func (rl *KeyedRateLimiter[K]) enableLocked(sh *shard[K], k K) {
+	if _, ok := sh.disabled[k]; ok {
+		delete(sh.disabled, k)
+		rl.disabledKeys.Add(-1)
+	}
+}
+
+// disabledKey reports whether k has been disabled with DisableKey.
+func (rl *KeyedRateLimiter[K]) disabledKey(k K) bool {
+	if rl.disabledKeys.Load() == 0 {
+		return false
+	}
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	_, ok := sh.disabled[k]
+	return ok
+}
@@ -0,0 +1,26 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisableKey(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.Limit("k")
+	rl.DisableKey("k")
+	for i := range 10 {
+		if rl.Limit("k") {
+			t.Fatalf("request %d for a disabled key limited", i)
+		}
+	}
+	rl.EnableKey("k")
+	if !rl.Limit("k") {
+		t.Error("re-enabled key not throttled")
+	}
+	if rl.Limit("other") || !rl.Limit("other") {
+		t.Error("another key not throttled normally")
+	}
+}
@@ -0,0 +1,33 @@
+package ratelimiter
+
+import "context"
+
+// DrainAndClose shuts the limiter down cleanly for servers that block in
+// Wait: from the call on, Wait, WaitN and WaitUntilAllowed return
+// ErrClosed at once, while the callers already waiting are given until ctx
+// is done to get their tokens, after which their waits are cancelled and
+// return a context error. Once every waiter has returned it calls Close.
+// It returns ctx's error if waits had to be cancelled, and nil otherwise.
+// The non-blocking methods keep working throughout.
+func (rl *KeyedRateLimiter[K]) DrainAndClose(ctx context.Context) error {
+	rl.drainMu.Lock()
+	rl.draining = true
+	rl.drainMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		rl.inFlight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		rl.haltWaiters()
+		<-drained
+	}
+	rl.Close()
+	return err
+}
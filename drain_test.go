@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDrainAndClose(t *testing.T) {
+	rl := New(time.Minute, 1, 1)
+	rl.Limit("k")
+
+	done := make(chan error)
+	go func() { done <- rl.Wait(context.Background(), "k") }()
+	for rl.waiters("k") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := rl.DrainAndClose(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DrainAndClose = %v, want DeadlineExceeded", err)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("waiter cut short got %v, want Canceled", err)
+	}
+	if err := rl.Wait(context.Background(), "other"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Wait after DrainAndClose = %v, want ErrClosed", err)
+	}
+	if err := rl.Healthy(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Healthy after DrainAndClose = %v, want ErrClosed", err)
+	}
+}
+
+func TestDrainAndCloseIdle(t *testing.T) {
+	rl := New(time.Minute, 1, 1)
+	if err := rl.DrainAndClose(context.Background()); err != nil {
+		t.Errorf("DrainAndClose with no waiters = %v", err)
+	}
+}
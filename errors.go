@@ -0,0 +1,33 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimitError is the error LimitOrError returns for a limited request.
+// Use errors.As to get at its fields, for example to render a Retry-After
+// header.
+type RateLimitError[K comparable] struct {
+	Key        K             // The key the request was limited on.
+	RetryAfter time.Duration // How long until a token is available, 0 if it can't be computed.
+}
+
+// Error implements error.
+func (e *RateLimitError[K]) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("ratelimiter: rate limit exceeded for key %v, retry after %v", e.Key, e.RetryAfter)
+	}
+	return fmt.Sprintf("ratelimiter: rate limit exceeded for key %v", e.Key)
+}
+
+// LimitOrError is like Check but returns nil if the request is allowed and
+// a *RateLimitError if it should be limited, for handlers that treat
+// being limited as an error.
+func (rl *KeyedRateLimiter[K]) LimitOrError(k K) error {
+	res := rl.Check(k)
+	if res.Allowed {
+		return nil
+	}
+	return &RateLimitError[K]{Key: k, RetryAfter: res.RetryAfter}
+}
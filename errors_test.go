@@ -0,0 +1,24 @@
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimitOrError(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	if err := rl.LimitOrError("k"); err != nil {
+		t.Fatalf("LimitOrError with a token = %v", err)
+	}
+	err := rl.LimitOrError("k")
+	var rlErr *RateLimitError[string]
+	if !errors.As(err, &rlErr) {
+		t.Fatalf("LimitOrError without a token = %v, want a *RateLimitError", err)
+	}
+	if rlErr.Key != "k" || rlErr.RetryAfter != time.Second {
+		t.Errorf("RateLimitError = %+v, want key k retrying after 1s", rlErr)
+	}
+}
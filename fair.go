@@ -0,0 +1,144 @@
+package ratelimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// fairShare tracks how much of the global bucket each key has been given
+// recently, so that under contention admissions can be split between keys
+// in proportion to their weights.
+type fairShare[K comparable] struct {
+	mu      sync.Mutex
+	window  time.Duration
+	weights map[K]float64 // Set with SetKeyWeight, 1 if absent.
+	usage   map[K]*usage  // Keys that made a request during the last window.
+
+	total       usage     // Recent admissions across all keys.
+	totalWeight float64   // Sum of the weights of the keys in usage.
+	sweptAt     time.Time // When inactive keys were last dropped from usage.
+}
+
+// usage is a count of admissions that decays exponentially over the
+// window, so older admissions count for less.
+type usage struct {
+	count       float64
+	at          time.Time // When count was last decayed.
+	requestedAt time.Time // When the key last asked for a token.
+}
+
+// decay brings u's count forward to now.
+func (u *usage) decay(now time.Time, window time.Duration) {
+	if d := now.Sub(u.at); d > 0 {
+		u.count *= math.Exp(-float64(d) / float64(window))
+		u.at = now
+	}
+}
+
+// newFairShare returns a fairShare remembering usage over window, or nil
+// if window isn't positive or there is no global bucket to share.
+func newFairShare[K comparable](window time.Duration, global *rate.Limiter) *fairShare[K] {
+	if window <= 0 || global == nil {
+		return nil
+	}
+	return &fairShare[K]{
+		window:  window,
+		weights: make(map[K]float64),
+		usage:   make(map[K]*usage),
+	}
+}
+
+// admit reports whether k may compete for a global token at now. While the
+// bucket isn't constrained every key may; once it is, only keys whose
+// weighted usage is no more than the fair level across active keys may.
+func (f *fairShare[K]) admit(k K, now time.Time, constrained bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sweep(now)
+	u := f.active(k, now)
+	if !constrained {
+		return true
+	}
+
+	u.decay(now, f.window)
+	f.total.decay(now, f.window)
+	return u.count/f.weight(k) <= f.total.count/f.totalWeight
+}
+
+// record counts n tokens granted to k at now.
+func (f *fairShare[K]) record(k K, now time.Time, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u := f.active(k, now)
+	u.decay(now, f.window)
+	u.count += float64(n)
+	f.total.decay(now, f.window)
+	f.total.count += float64(n)
+}
+
+// active returns k's usage, adding k to the active keys if needed. The
+// caller must hold f.mu.
+func (f *fairShare[K]) active(k K, now time.Time) *usage {
+	u, ok := f.usage[k]
+	if !ok {
+		u = &usage{at: now}
+		f.usage[k] = u
+		f.totalWeight += f.weight(k)
+	}
+	u.requestedAt = now
+	return u
+}
+
+// sweep drops the keys that haven't asked for a token during the last
+// window, at most once per window. The caller must hold f.mu.
+func (f *fairShare[K]) sweep(now time.Time) {
+	if now.Sub(f.sweptAt) < f.window {
+		return
+	}
+	f.sweptAt = now
+
+	f.totalWeight = 0
+	for k, u := range f.usage {
+		if now.Sub(u.requestedAt) > f.window {
+			delete(f.usage, k)
+			continue
+		}
+		f.totalWeight += f.weight(k)
+	}
+}
+
+// weight returns k's weight. The caller must hold f.mu.
+func (f *fairShare[K]) weight(k K) float64 {
+	if w, ok := f.weights[k]; ok {
+		return w
+	}
+	return 1
+}
+
+// setWeight changes k's weight, keeping the active total in step.
+func (f *fairShare[K]) setWeight(k K, w float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.usage[k]; ok {
+		f.totalWeight += w - f.weight(k)
+	}
+	f.weights[k] = w
+}
+
+// SetKeyWeight sets k's weight for WithFairShare: under contention for the
+// global bucket a key with weight 3 is entitled to three times the share
+// of one with weight 1. Weights must be positive. It has no effect unless
+// WithFairShare and WithGlobalLimit are used.
+func (rl *KeyedRateLimiter[K]) SetKeyWeight(k K, w float64) {
+	k = rl.keyOf(k)
+	if rl.fair == nil || w <= 0 {
+		return
+	}
+	rl.fair.setWeight(k, w)
+}
@@ -0,0 +1,28 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFairShare(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1000, 1000, WithGlobalLimit(100, 10), WithFairShare(10*time.Second), WithClock(clock))
+	defer rl.Close()
+	rl.SetKeyWeight("light", 1)
+	rl.SetKeyWeight("heavy", 3)
+
+	allowed := map[string]int{}
+	for range 2000 {
+		clock.Advance(time.Millisecond)
+		for _, k := range []string{"light", "heavy", "heavy", "light"} {
+			if !rl.Limit(k) {
+				allowed[k]++
+			}
+		}
+	}
+	ratio := float64(allowed["heavy"]) / float64(allowed["light"])
+	if ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("admitted %d heavy and %d light requests, ratio %.2f, want about 3", allowed["heavy"], allowed["light"], ratio)
+	}
+}
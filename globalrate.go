@@ -0,0 +1,61 @@
+package ratelimiter
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// rateTick is how often the GlobalRate average takes in the requests
+// counted since the last tick.
+const rateTick = time.Second
+
+// rateWindow is the time constant of the GlobalRate average: requests
+// older than it have less than a third of the weight of current ones.
+const rateWindow = 10 * time.Second
+
+// ewma is an exponentially weighted moving average of the rate of
+// allowed requests, updated without locks.
+type ewma struct {
+	count atomic.Int64  // Requests since the last tick.
+	tick  atomic.Int64  // Offset from the limiter's epoch of the last tick.
+	rate  atomic.Uint64 // The average in requests per second, as float64 bits.
+}
+
+// record counts one request at at, an offset from the limiter's epoch.
+func (e *ewma) record(at time.Duration) {
+	e.advance(at)
+	e.count.Add(1)
+}
+
+// advance folds the requests counted so far into the average if a tick
+// has passed by at. Only the caller that claims the tick does so.
+func (e *ewma) advance(at time.Duration) {
+	last := e.tick.Load()
+	ticks := (int64(at) - last) / int64(rateTick)
+	if ticks <= 0 || !e.tick.CompareAndSwap(last, last+ticks*int64(rateTick)) {
+		return
+	}
+	n := float64(e.count.Swap(0))
+	alpha := math.Exp(-float64(rateTick) / float64(rateWindow))
+	r := math.Float64frombits(e.rate.Load())
+	r = alpha*r + (1-alpha)*n/rateTick.Seconds()
+	// Ticks without requests decay the average further.
+	r *= math.Pow(alpha, float64(ticks-1))
+	e.rate.Store(math.Float64bits(r))
+}
+
+// GlobalRate returns a smoothed estimate of the requests per second the
+// limiter is allowing across all keys, for example as an autoscaling
+// signal. It is an exponentially weighted moving average over roughly
+// the last ten seconds, updated once a second. Counting starts with the
+// first call to GlobalRate, so limiters that never call it don't pay for
+// the shared counter, and the estimate takes a few windows to warm up.
+func (rl *KeyedRateLimiter[K]) GlobalRate() float64 {
+	at := rl.since(rl.clock.Now())
+	if !rl.rating.Load() && rl.rating.CompareAndSwap(false, true) {
+		rl.throughput.tick.Store(int64(at))
+	}
+	rl.throughput.advance(at)
+	return math.Float64frombits(rl.throughput.rate.Load())
+}
@@ -0,0 +1,30 @@
+package ratelimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGlobalRate(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1000, 1000, WithClock(clock))
+	defer rl.Close()
+
+	if got := rl.GlobalRate(); got != 0 {
+		t.Errorf("GlobalRate before any requests = %v, want 0", got)
+	}
+	for range 60 {
+		for range 50 {
+			rl.Limit("k")
+		}
+		clock.Advance(time.Second)
+	}
+	if got := rl.GlobalRate(); math.Abs(got-50) > 1 {
+		t.Errorf("GlobalRate at 50 requests a second = %v", got)
+	}
+	clock.Advance(time.Minute)
+	if got := rl.GlobalRate(); got > 1 {
+		t.Errorf("GlobalRate a minute after the last request = %v", got)
+	}
+}
@@ -0,0 +1,69 @@
+// Package grpc provides grpc-go server interceptors that reject calls
+// with codes.ResourceExhausted when a ratelimiter.Limiter limits them.
+package grpc
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/simplifyd-systems/ratelimiter"
+)
+
+// KeyFunc derives the rate limit key for a call from its context, for
+// example from the peer address or a metadata value.
+type KeyFunc func(ctx context.Context) string
+
+// UnaryServerInterceptor returns an interceptor that limits unary calls by
+// the key keyFunc derives from each call. A nil keyFunc limits by PeerIP.
+func UnaryServerInterceptor(l ratelimiter.Limiter, keyFunc KeyFunc) grpc.UnaryServerInterceptor {
+	if keyFunc == nil {
+		keyFunc = PeerIP
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if l.Limit(keyFunc(ctx)) {
+			return nil, limitedError(info.FullMethod)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor that limits the opening
+// of streams by the key keyFunc derives from each stream's context. A nil
+// keyFunc limits by PeerIP.
+func StreamServerInterceptor(l ratelimiter.Limiter, keyFunc KeyFunc) grpc.StreamServerInterceptor {
+	if keyFunc == nil {
+		keyFunc = PeerIP
+	}
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if l.Limit(keyFunc(ss.Context())) {
+			return limitedError(info.FullMethod)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// PeerIP returns the IP address of the call's peer with the port
+// stripped, or an empty string if the context carries no peer.
+func PeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func limitedError(method string) error {
+	return status.Errorf(codes.ResourceExhausted, "%s is rate limited, retry later", method)
+}
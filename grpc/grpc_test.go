@@ -0,0 +1,102 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/simplifyd-systems/ratelimiter"
+)
+
+// newClient serves the health service over an in-memory connection, with
+// unary calls and streams limited by separate limiters of the given
+// burst, and returns a client for it.
+func newClient(t *testing.T, burst int) healthpb.HealthClient {
+	t.Helper()
+	unary := ratelimiter.New(time.Minute, 0, burst)
+	stream := ratelimiter.New(time.Minute, 0, burst)
+	t.Cleanup(unary.Close)
+	t.Cleanup(stream.Close)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(unary, nil)),
+		grpc.StreamInterceptor(StreamServerInterceptor(stream, nil)),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client := newClient(t, 2)
+	ctx := context.Background()
+
+	for i := range 2 {
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("call %d within the burst failed: %v", i, err)
+		}
+	}
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if got := status.Code(err); got != codes.ResourceExhausted {
+		t.Errorf("call past the burst got %v, want ResourceExhausted", got)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := newClient(t, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch := func() error {
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+	if err := watch(); err != nil {
+		t.Fatalf("stream within the burst failed: %v", err)
+	}
+	if got := status.Code(watch()); got != codes.ResourceExhausted {
+		t.Errorf("stream past the burst got %v, want ResourceExhausted", got)
+	}
+}
+
+func TestPeerIP(t *testing.T) {
+	for addr, want := range map[net.Addr]string{
+		&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 80}:     "192.0.2.1",
+		&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80}: "2001:db8::1",
+	} {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		if got := PeerIP(ctx); got != want {
+			t.Errorf("PeerIP(%v) = %q, want %q", addr, got, want)
+		}
+	}
+	if got := PeerIP(context.Background()); got != "" {
+		t.Errorf("PeerIP without a peer = %q, want none", got)
+	}
+}
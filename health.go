@@ -0,0 +1,42 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+)
+
+// Healthy reports whether the limiter is working, for readiness probes:
+// it returns ErrClosed once Close has been called, which also stops
+// stale entries being removed, and otherwise checks that every shard can
+// be locked before ctx is done, returning an error wrapping ctx's error if
+// one can't.
+func (rl *KeyedRateLimiter[K]) Healthy(ctx context.Context) error {
+	select {
+	case <-rl.done:
+		return ErrClosed
+	default:
+	}
+
+	// A shard whose lock is never released would block the check for
+	// good, so it runs apart from the wait on ctx.
+	locked := make(chan struct{})
+	go func() {
+		for _, sh := range rl.shards {
+			sh.mu.RLock()
+			sh.mu.RUnlock()
+		}
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("ratelimiter: shards not accessible: %w", ctx.Err())
+	}
+}
+
+// Healthy reports whether the limiter is working, as
+// KeyedRateLimiter.Healthy does.
+func (sl *StrategyLimiter[K]) Healthy(ctx context.Context) error {
+	return sl.rl.Healthy(ctx)
+}
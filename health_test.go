@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthy(t *testing.T) {
+	rl := New(time.Minute, 1, 1)
+	ns := rl.Namespaced("ns")
+	sl := NewStrategy(time.Minute, FixedWindow(1, time.Minute))
+	ctx := context.Background()
+	for name, l := range map[string]Limiter{"RateLimiter": rl, "StrategyLimiter": sl, "Namespace": ns} {
+		if err := l.Healthy(ctx); err != nil {
+			t.Errorf("%s: Healthy while running = %v", name, err)
+		}
+	}
+	rl.Close()
+	sl.Close()
+	for name, l := range map[string]Limiter{"RateLimiter": rl, "StrategyLimiter": sl, "Namespace": ns} {
+		if err := l.Healthy(ctx); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s: Healthy after Close = %v, want ErrClosed", name, err)
+		}
+	}
+}
+
+func TestHealthyStuckShard(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithShards(1))
+	defer rl.Close()
+
+	sh := rl.shards[0]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rl.Healthy(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Healthy with a shard locked = %v, want one wrapping DeadlineExceeded", err)
+	}
+}
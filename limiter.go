@@ -0,0 +1,60 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+)
+
+// Limiter is the keyed limiting behaviour shared by RateLimiter and the
+// distributed backends, so callers can swap one for another or substitute
+// a fake in tests.
+type Limiter interface {
+	// Limit returns true if a request for k should be limited.
+	Limit(k string) bool
+	// RemoveEntry forgets any state held for k, reporting whether there
+	// was any.
+	RemoveEntry(k string) bool
+	// Close releases any background resources held by the limiter.
+	Close()
+	// Healthy returns nil if the limiter is working, or an error saying
+	// why not, such as the limiter being closed or its store not
+	// answering before ctx is done, for readiness probes.
+	Healthy(ctx context.Context) error
+}
+
+// FailMode is what a Limiter whose backend can fail, such as the one in
+// the redis package, decides when it can't reach the backend. The
+// in-memory limiters never fail, so it doesn't affect them.
+type FailMode int
+
+const (
+	// FailOpen allows requests while the backend is failing, putting
+	// availability first. It is the default.
+	FailOpen FailMode = iota
+	// FailClosed limits requests while the backend is failing, putting
+	// protection of the service first.
+	FailClosed
+)
+
+// Limited reports whether a request that couldn't be checked because of
+// a backend failure should be limited.
+func (m FailMode) Limited() bool {
+	return m == FailClosed
+}
+
+// String returns the name of the mode.
+func (m FailMode) String() string {
+	switch m {
+	case FailOpen:
+		return "FailOpen"
+	case FailClosed:
+		return "FailClosed"
+	}
+	return "FailMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+var (
+	_ Limiter = (*RateLimiter)(nil)
+	_ Limiter = (*StrategyLimiter[string])(nil)
+	_ Limiter = (*Namespace)(nil)
+)
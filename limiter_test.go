@@ -0,0 +1,84 @@
+package ratelimiter
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mockLimiter is a Limiter that limits the keys in limited and records
+// the calls made to it.
+type mockLimiter struct {
+	limited map[string]bool
+	calls   []string
+	closed  bool
+}
+
+func (m *mockLimiter) Limit(k string) bool {
+	m.calls = append(m.calls, "Limit "+k)
+	return m.limited[k]
+}
+
+func (m *mockLimiter) RemoveEntry(k string) bool {
+	m.calls = append(m.calls, "RemoveEntry "+k)
+	return m.limited[k]
+}
+
+func (m *mockLimiter) Close() { m.closed = true }
+
+func (m *mockLimiter) Healthy(context.Context) error {
+	if m.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// firstLimited returns the first of keys l limits.
+func firstLimited(l Limiter, keys ...string) string {
+	for _, k := range keys {
+		if l.Limit(k) {
+			return k
+		}
+	}
+	return ""
+}
+
+func TestLimiter(t *testing.T) {
+	mock := &mockLimiter{limited: map[string]bool{"b": true}}
+	rl := New(time.Minute, 0, 1)
+	rl.BlockKey("b", 0)
+	sl := NewStrategy(time.Minute, FixedWindow(1, time.Hour))
+	sl.Limit("b")
+	base := New(time.Minute, 0, 1)
+	defer base.Close()
+	ns := base.Namespaced("ns")
+	ns.Limit("b")
+
+	for name, l := range map[string]Limiter{"mock": mock, "RateLimiter": rl, "StrategyLimiter": sl, "Namespace": ns} {
+		if got := firstLimited(l, "a", "b", "c"); got != "b" {
+			t.Errorf("%s: first limited key = %q, want b", name, got)
+		}
+		if err := l.Healthy(context.Background()); err != nil {
+			t.Errorf("%s: Healthy = %v", name, err)
+		}
+		l.Close()
+	}
+	if want := []string{"Limit a", "Limit b"}; !slices.Equal(mock.calls, want) {
+		t.Errorf("mock got calls %q, want %q", mock.calls, want)
+	}
+	if !mock.closed {
+		t.Error("mock not closed")
+	}
+}
+
+func TestFailMode(t *testing.T) {
+	if FailOpen.Limited() || !FailClosed.Limited() {
+		t.Error("FailOpen limits or FailClosed doesn't")
+	}
+	for m, want := range map[FailMode]string{FailOpen: "FailOpen", FailClosed: "FailClosed", 7: "FailMode(7)"} {
+		if got := m.String(); got != want {
+			t.Errorf("String = %q, want %q", got, want)
+		}
+	}
+}
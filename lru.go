@@ -0,0 +1,63 @@
+package ratelimiter
+
+// insert adds v to the shard as its most recently seen entry, first
+// evicting the least recently seen one if the shard is at capacity. The
+// caller must hold sh.mu.
+func (sh *shard[K]) insert(v *entry[K]) {
+	if sh.capacity > 0 {
+		if len(sh.entries) >= sh.capacity && sh.tail != nil {
+			sh.remove(sh.tail.key)
+		}
+		sh.pushFront(v)
+	}
+	sh.entries[v.key] = v
+}
+
+// remove deletes k's entry from the shard, reporting whether it had one.
+// The caller must hold sh.mu.
+func (sh *shard[K]) remove(k K) bool {
+	v, exists := sh.entries[k]
+	if !exists {
+		return false
+	}
+	delete(sh.entries, k)
+	if sh.capacity > 0 {
+		sh.unlink(v)
+	}
+	return true
+}
+
+// touch marks v as the shard's most recently seen entry. The caller must
+// hold sh.mu.
+func (sh *shard[K]) touch(v *entry[K]) {
+	if sh.capacity == 0 || sh.head == v {
+		return
+	}
+	sh.unlink(v)
+	sh.pushFront(v)
+}
+
+func (sh *shard[K]) pushFront(v *entry[K]) {
+	v.prev, v.next = nil, sh.head
+	if sh.head != nil {
+		sh.head.prev = v
+	}
+	sh.head = v
+	if sh.tail == nil {
+		sh.tail = v
+	}
+}
+
+func (sh *shard[K]) unlink(v *entry[K]) {
+	if v.prev != nil {
+		v.prev.next = v.next
+	} else {
+		sh.head = v.next
+	}
+	if v.next != nil {
+		v.next.prev = v.prev
+	} else {
+		sh.tail = v.prev
+	}
+	v.prev, v.next = nil, nil
+}
@@ -0,0 +1,28 @@
+package ratelimiter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMaxKeys(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithMaxKeys(3), WithShards(1), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for _, k := range []string{"a", "b", "c", "a", "d"} {
+		rl.Limit(k)
+	}
+	for k, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, tracked := rl.FirstSeen(k); tracked != want {
+			t.Errorf("%s tracked = %v, want %v", k, tracked, want)
+		}
+	}
+
+	for i := range 100 {
+		rl.Limit(strconv.Itoa(i))
+		if got := rl.Count(); got > 3 {
+			t.Fatalf("Count = %d after %d keys, want at most 3", got, i+1)
+		}
+	}
+}
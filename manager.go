@@ -0,0 +1,128 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Manager hosts a set of named limiters that share one cleanup goroutine,
+// for applications with dozens of distinct limiters that would otherwise
+// each run their own.
+type Manager struct {
+	interval time.Duration
+	mu       sync.Mutex
+	limiters map[string]*RateLimiter
+
+	done      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewManager returns a Manager whose goroutine calls Cleanup on every
+// limiter it hosts each cleanupInterval. A zero interval doesn't start the
+// goroutine, leaving the caller to call Cleanup. Call Close to stop it.
+func NewManager(cleanupInterval time.Duration) *Manager {
+	m := &Manager{
+		interval: cleanupInterval,
+		limiters: make(map[string]*RateLimiter),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	if cleanupInterval > 0 {
+		go m.run()
+	} else {
+		close(m.exited)
+	}
+	return m
+}
+
+func (m *Manager) run() {
+	defer close(m.exited)
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-time.After(m.interval):
+			m.Cleanup()
+		}
+	}
+}
+
+// New creates a limiter as New does, named name, that is reaped by the
+// manager's goroutine instead of one of its own. The options behave as
+// they do for New, except that WithClock and WithJitter don't affect the
+// schedule, which is the manager's. If name is already in use, the
+// limiter it names is closed and replaced.
+func (m *Manager) New(name string, ratePerSec rate.Limit, burstPerPeriod int, opts ...Option) *RateLimiter {
+	if m.interval > defaultExpiry {
+		// Give entries the expiry New would have given them with the
+		// manager's interval. Later options override it.
+		opts = append([]Option{WithExpiry(m.interval)}, opts...)
+	}
+	rl := New(0, ratePerSec, burstPerPeriod, opts...)
+
+	m.mu.Lock()
+	old, ok := m.limiters[name]
+	m.limiters[name] = rl
+	m.mu.Unlock()
+	if ok {
+		old.Close()
+	}
+	return rl
+}
+
+// Get returns the limiter named name, or nil if there is none.
+func (m *Manager) Get(name string) *RateLimiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.limiters[name]
+}
+
+// Remove closes the limiter named name and stops hosting it. It reports
+// whether there was one.
+func (m *Manager) Remove(name string) bool {
+	m.mu.Lock()
+	rl, ok := m.limiters[name]
+	delete(m.limiters, name)
+	m.mu.Unlock()
+	if ok {
+		rl.Close()
+	}
+	return ok
+}
+
+// Cleanup calls Cleanup on every hosted limiter, as the manager's
+// goroutine does each interval.
+func (m *Manager) Cleanup() {
+	for _, rl := range m.hosted() {
+		rl.Cleanup()
+	}
+}
+
+// hosted returns the hosted limiters, so they can be used without holding
+// m.mu.
+func (m *Manager) hosted() []*RateLimiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limiters := make([]*RateLimiter, 0, len(m.limiters))
+	for _, rl := range m.limiters {
+		limiters = append(limiters, rl)
+	}
+	return limiters
+}
+
+// Close stops the manager's goroutine, waits for it to exit and then
+// closes every hosted limiter. The limiters keep working afterwards, but
+// stale entries are no longer removed. Calling Close more than once is a
+// no-op.
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		<-m.exited
+		for _, rl := range m.hosted() {
+			rl.Close()
+		}
+	})
+}
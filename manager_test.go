@@ -0,0 +1,40 @@
+package ratelimiter
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	before := runtime.NumGoroutine()
+	m := NewManager(time.Millisecond)
+	defer m.Close()
+
+	clock := newFakeClock()
+	var limiters []*RateLimiter
+	for i := range 5 {
+		rl := m.New(strconv.Itoa(i), 1, 1, WithClock(clock))
+		rl.Limit("k")
+		limiters = append(limiters, rl)
+	}
+	if got := runtime.NumGoroutine() - before; got != 1 {
+		t.Errorf("%d goroutines running for 5 hosted limiters, want 1", got)
+	}
+	if m.Get("3") != limiters[3] {
+		t.Error("Get returned a different limiter")
+	}
+	if m.Get("missing") != nil {
+		t.Error("Get returned a limiter for an unknown name")
+	}
+
+	clock.Advance(defaultExpiry + time.Second)
+	for i, rl := range limiters {
+		waitFor(t, "limiter "+strconv.Itoa(i)+" to be reaped", func() bool { return rl.Count() == 0 })
+	}
+
+	if !m.Remove("0") || m.Remove("0") {
+		t.Error("Remove didn't report whether the limiter was hosted")
+	}
+}
@@ -0,0 +1,46 @@
+package ratelimiter
+
+import (
+	"unsafe"
+
+	"golang.org/x/time/rate"
+)
+
+// mapSlotOverhead approximates the bytes a map spends per element beyond
+// the key and value themselves: hash control bytes, load factor slack and
+// growth.
+const mapSlotOverhead = 16
+
+// MemoryUsage estimates the bytes held by the tracked entries, for sizing
+// WithMaxKeys: a fixed cost per entry for its bucket, bookkeeping and map
+// slot, plus the bytes of string keys. It walks every shard under its
+// read lock, so it costs about as much as Snapshot and shouldn't be
+// called on every request. The estimate leaves out overrides and the
+// allocator's rounding, so the real figure is somewhat higher.
+func (rl *KeyedRateLimiter[K]) MemoryUsage() int {
+	var k K
+	perEntry := int(unsafe.Sizeof(entry[K]{})+unsafe.Sizeof(rate.Limiter{})) +
+		int(unsafe.Sizeof(k)+unsafe.Sizeof(&entry[K]{})) + mapSlotOverhead
+	if rl.penalty != nil {
+		perEntry += int(unsafe.Sizeof(penaltyState{}))
+	}
+	if rl.stats != nil {
+		perEntry += int(unsafe.Sizeof(counters{}))
+	}
+
+	total := 0
+	for _, sh := range rl.shards {
+		sh.mu.RLock()
+		total += len(sh.entries) * perEntry
+		for key, v := range sh.entries {
+			if s, ok := any(key).(string); ok {
+				total += len(s)
+			}
+			if v.credit != nil {
+				total += int(unsafe.Sizeof(creditPool{}))
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return total
+}
@@ -0,0 +1,30 @@
+package ratelimiter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	if got := rl.MemoryUsage(); got != 0 {
+		t.Errorf("MemoryUsage with no entries = %d", got)
+	}
+	add := func(from, to int) {
+		for i := from; i < to; i++ {
+			rl.Limit(strconv.Itoa(1000 + i))
+		}
+	}
+	add(0, 100)
+	hundred := rl.MemoryUsage()
+	if hundred <= 0 {
+		t.Fatalf("MemoryUsage with 100 entries = %d", hundred)
+	}
+	add(100, 200)
+	if got := rl.MemoryUsage(); got != 2*hundred {
+		t.Errorf("MemoryUsage with 200 entries = %d, want twice the %d for 100", got, hundred)
+	}
+}
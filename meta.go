@@ -0,0 +1,38 @@
+package ratelimiter
+
+// eviction is a removed entry's key and metadata, kept to report to the
+// WithOnEvict callback once the shard is unlocked.
+type eviction[K comparable] struct {
+	k    K
+	meta any
+}
+
+// SetMeta attaches v, such as a tenant's plan or region, to k's entry,
+// creating the entry if needed, for observers and Range to look up with
+// the key instead of keeping a map beside the limiter that can drift from
+// its evictions. The metadata lives and dies with the entry: cleanup and
+// RemoveEntry pass it to the WithOnEvictMeta callback, if there is one,
+// as they drop it, ClearAll and WithMaxKeys drop it silently, and a
+// recreated entry starts without any. A nil v clears it.
+func (rl *KeyedRateLimiter[K]) SetMeta(k K, v any) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	rl.entryLocked(sh, k, rl.clock.Now()).meta = v
+}
+
+// Meta returns the metadata attached to k's entry with SetMeta, and
+// whether k is tracked at all. It may be called from observers, and from
+// Range callbacks to visit entries with their metadata.
+func (rl *KeyedRateLimiter[K]) Meta(k K) (any, bool) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	v, exists := sh.entries[k]
+	if !exists {
+		return nil, false
+	}
+	return v.meta, true
+}
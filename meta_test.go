@@ -0,0 +1,37 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	clock := newFakeClock()
+	evicted := map[string]any{}
+	rl := New(time.Minute, 1, 1, WithClock(clock),
+		WithOnEvictMeta(func(k string, meta any) { evicted[k] = meta }))
+	defer rl.Close()
+
+	rl.SetMeta("k", "gold")
+	seen := map[string]any{}
+	rl.Range(func(k string, _ time.Time, _ float64) bool {
+		seen[k], _ = rl.Meta(k)
+		return true
+	})
+	if seen["k"] != "gold" {
+		t.Errorf("metadata seen by Range = %v, want gold", seen["k"])
+	}
+
+	clock.Advance(defaultExpiry + time.Second)
+	rl.Cleanup()
+	if evicted["k"] != "gold" {
+		t.Errorf("metadata passed to WithOnEvictMeta = %v, want gold", evicted["k"])
+	}
+	if meta, ok := rl.Meta("k"); meta != nil || ok {
+		t.Errorf("Meta after eviction = %v, %v", meta, ok)
+	}
+	rl.Limit("k")
+	if meta, _ := rl.Meta("k"); meta != nil {
+		t.Errorf("recreated entry has metadata %v", meta)
+	}
+}
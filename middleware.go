@@ -0,0 +1,153 @@
+package ratelimiter
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/time/rate"
+)
+
+// MiddlewareOption configures the responses of Middleware.
+type MiddlewareOption func(*middlewareOptions)
+
+// middlewareOptions holds the settings collected from MiddlewareOptions.
+type middlewareOptions struct {
+	limited    http.Handler
+	retryAfter bool
+	headers    bool
+}
+
+// WithLimitedHandler makes Middleware answer limited requests with h, for
+// example to write a JSON error envelope or use another status code,
+// instead of a plain 429 Too Many Requests. Headers Middleware sets, such
+// as Retry-After, are already on the response when h is called.
+func WithLimitedHandler(h http.Handler) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.limited = h
+	}
+}
+
+// WithRetryAfterHeader says whether Middleware sets a Retry-After header,
+// in whole seconds, on limited responses. It does by default, when the
+// delay can be computed.
+func WithRetryAfterHeader(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.retryAfter = enabled
+	}
+}
+
+// WithRateLimitHeaders makes Middleware set X-RateLimit-Limit to the
+// key's burst, X-RateLimit-Remaining to the whole tokens left and, when
+// the bucket refills, X-RateLimit-Reset to the seconds until it is full
+// again, on every response, allowed or limited, except for allowlisted
+// clients.
+func WithRateLimitHeaders() MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.headers = true
+	}
+}
+
+// Middleware returns net/http middleware that limits requests by the key
+// keyFunc derives from each request, such as the client IP, a header or
+// an API key. Limited requests get a 429 Too Many Requests response with a
+// Retry-After header when the delay can be computed; opts change the
+// response and add headers. A nil keyFunc limits by RemoteIP, which
+// requires string keys; pass RemoteCIDR to limit by subnet instead.
+// Clients in a network added with AllowPrefix are never limited.
+func (rl *KeyedRateLimiter[K]) Middleware(keyFunc func(*http.Request) K, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	if keyFunc == nil {
+		f, ok := any(RemoteIP).(func(*http.Request) K)
+		if !ok {
+			panic("ratelimiter: Middleware needs a keyFunc for non-string keys")
+		}
+		keyFunc = f
+	}
+	o := middlewareOptions{retryAfter: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rl.allowlistedRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			k := keyFunc(r)
+			res := rl.Check(k)
+			if o.headers {
+				rl.setRateLimitHeaders(w.Header(), k)
+			}
+			if res.Allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if o.retryAfter && res.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+			}
+			if o.limited != nil {
+				o.limited.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		})
+	}
+}
+
+// setRateLimitHeaders sets the X-RateLimit headers for k, as
+// WithRateLimitHeaders describes.
+func (rl *KeyedRateLimiter[K]) setRateLimitHeaders(h http.Header, k K) {
+	tokens, r, burst := rl.level(rl.keyOf(k), rl.clock.Now())
+	h.Set("X-RateLimit-Limit", strconv.Itoa(burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(min(max(math.Floor(tokens), 0), float64(burst)))))
+	if r > 0 && r != rate.Inf {
+		missing := max(float64(burst)-tokens, 0)
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(missing/float64(r)))))
+	}
+}
+
+// RemoteIP returns the IP address from r.RemoteAddr with the port
+// stripped. It does not look at proxy headers such as X-Forwarded-For.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// CIDRKey returns the network of ip with the given prefix length in CIDR
+// notation, such as "192.0.2.0/24", for limiting a whole subnet with one
+// bucket. IPv4 addresses, including IPv4-mapped IPv6 ones, are masked to
+// prefixLen of 32 bits and other addresses to prefixLen of 128; lengths
+// outside that range are clamped. It returns "" for an invalid ip.
+func CIDRKey(ip net.IP, prefixLen int) string {
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	} else if ip = ip.To16(); ip == nil {
+		return ""
+	}
+	prefixLen = min(max(prefixLen, 0), bits)
+	return ip.Mask(net.CIDRMask(prefixLen, bits)).String() + "/" + strconv.Itoa(prefixLen)
+}
+
+// RemoteCIDR returns a key function for Middleware that limits requests
+// by the subnet of their RemoteIP, using a prefix of v4Len bits for IPv4
+// clients and v6Len bits for IPv6 ones, such as 24 and 64. Addresses that
+// don't parse are used as the key unchanged.
+func RemoteCIDR(v4Len, v6Len int) func(*http.Request) string {
+	return func(r *http.Request) string {
+		host := RemoteIP(r)
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return host
+		}
+		if ip.To4() != nil {
+			return CIDRKey(ip, v4Len)
+		}
+		return CIDRKey(ip, v6Len)
+	}
+}
@@ -0,0 +1,144 @@
+package ratelimiter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// serve sends a request from remoteAddr through h and returns the
+// response.
+func serve(h http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestMiddleware(t *testing.T) {
+	rl := New(time.Minute, 0.5, 3, WithClock(newFakeClock()))
+	defer rl.Close()
+	h := rl.Middleware(nil)(okHandler)
+
+	for i := range 3 {
+		if w := serve(h, "192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst got %d", i, w.Code)
+		}
+	}
+	w := serve(h, "192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst got %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if w := serve(h, "192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("another client got %d", w.Code)
+	}
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	rl := New(time.Minute, 1, 2, WithClock(newFakeClock()))
+	defer rl.Close()
+	teapot := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := rl.Middleware(nil, WithLimitedHandler(teapot), WithRetryAfterHeader(false), WithRateLimitHeaders())(okHandler)
+
+	w := serve(h, "192.0.2.1:1")
+	if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+		t.Errorf("X-RateLimit-Limit = %q, want 2", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 1", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Reset"); got != "1" {
+		t.Errorf("X-RateLimit-Reset = %q, want 1", got)
+	}
+
+	serve(h, "192.0.2.1:1")
+	w = serve(h, "192.0.2.1:1")
+	if w.Code != http.StatusTeapot {
+		t.Errorf("limited request got %d, want the limited handler's 418", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("Retry-After set although disabled")
+	}
+}
+
+func TestMiddlewareAllowPrefix(t *testing.T) {
+	rl := New(time.Minute, 0, 0)
+	defer rl.Close()
+	rl.AllowPrefix(netip.MustParsePrefix("10.0.0.0/8"))
+	h := rl.Middleware(nil)(okHandler)
+
+	if w := serve(h, "10.1.2.3:1"); w.Code != http.StatusOK {
+		t.Errorf("allowlisted client got %d", w.Code)
+	}
+	if w := serve(h, "192.0.2.1:1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("other client got %d, want 429", w.Code)
+	}
+}
+
+func TestMiddlewareNewKeyLimit(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithNewKeyLimit(1, 1), WithClock(newFakeClock()))
+	defer rl.Close()
+	h := rl.Middleware(nil)(okHandler)
+
+	serve(h, "192.0.2.1:1")
+	w := serve(h, "192.0.2.2:1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("refused new key got %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if got := rl.Count(); got != 1 {
+		t.Errorf("Count = %d, want 1: an entry was created for the refused key", got)
+	}
+}
+
+func TestCIDRKey(t *testing.T) {
+	for _, tt := range []struct {
+		ip   string
+		bits int
+		want string
+	}{
+		{"192.0.2.77", 24, "192.0.2.0/24"},
+		{"::ffff:192.0.2.77", 16, "192.0.0.0/16"},
+		{"2001:db8::1", 48, "2001:db8::/48"},
+		{"192.0.2.77", 40, "192.0.2.77/32"},
+		{"bogus", 24, ""},
+	} {
+		if got := CIDRKey(net.ParseIP(tt.ip), tt.bits); got != tt.want {
+			t.Errorf("CIDRKey(%s, %d) = %q, want %q", tt.ip, tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestRemoteCIDR(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+	h := rl.Middleware(RemoteCIDR(24, 64))(okHandler)
+
+	for _, tt := range []struct {
+		addr string
+		want int
+	}{
+		{"192.0.2.1:1", http.StatusOK},
+		{"192.0.2.200:1", http.StatusTooManyRequests},
+		{"192.0.3.1:1", http.StatusOK},
+		{"[2001:db8::1]:1", http.StatusOK},
+		{"[2001:db8::2]:1", http.StatusTooManyRequests},
+	} {
+		if w := serve(h, tt.addr); w.Code != tt.want {
+			t.Errorf("request from %s got %d, want %d", tt.addr, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimiter
+
+import "context"
+
+// Namespace is a view of a string keyed limiter that prefixes every key,
+// so several subsystems can share one limiter, and its cleanup goroutine,
+// without their keys colliding. Create one with Namespaced.
+type Namespace struct {
+	rl     *RateLimiter
+	prefix string
+}
+
+// Namespaced returns a view of rl whose keys are prefixed with prefix.
+// Prefixes should end with a separator no key contains, such as "ip:", so
+// that keys from different namespaces can't run together. It panics for
+// limiters with non-string keys.
+func (rl *KeyedRateLimiter[K]) Namespaced(prefix string) *Namespace {
+	srl, ok := any(rl).(*RateLimiter)
+	if !ok {
+		panic("ratelimiter: Namespaced needs a limiter with string keys")
+	}
+	return &Namespace{srl, prefix}
+}
+
+// Limit is the parent's Limit for the prefixed key.
+func (ns *Namespace) Limit(k string) bool {
+	return ns.rl.Limit(ns.prefix + k)
+}
+
+// LimitN is the parent's LimitN for the prefixed key.
+func (ns *Namespace) LimitN(k string, n int) bool {
+	return ns.rl.LimitN(ns.prefix+k, n)
+}
+
+// Wait is the parent's Wait for the prefixed key.
+func (ns *Namespace) Wait(ctx context.Context, k string) error {
+	return ns.rl.Wait(ctx, ns.prefix+k)
+}
+
+// Tokens is the parent's Tokens for the prefixed key.
+func (ns *Namespace) Tokens(k string) float64 {
+	return ns.rl.Tokens(ns.prefix + k)
+}
+
+// Reset is the parent's Reset for the prefixed key.
+func (ns *Namespace) Reset(k string) {
+	ns.rl.Reset(ns.prefix + k)
+}
+
+// RemoveEntry is the parent's RemoveEntry for the prefixed key.
+func (ns *Namespace) RemoveEntry(k string) bool {
+	return ns.rl.RemoveEntry(ns.prefix + k)
+}
+
+// Close does nothing: the parent limiter owns the cleanup goroutine and
+// other namespaces may still be using it.
+func (ns *Namespace) Close() {}
+
+// Healthy is the parent's Healthy.
+func (ns *Namespace) Healthy(ctx context.Context) error {
+	return ns.rl.Healthy(ctx)
+}
@@ -0,0 +1,31 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNamespaced(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+	ip, user := rl.Namespaced("ip:"), rl.Namespaced("user:")
+
+	if ip.Limit("k") || user.Limit("k") {
+		t.Fatal("first request in a namespace limited")
+	}
+	if !ip.Limit("k") {
+		t.Error("second request in one namespace allowed")
+	}
+	if rl.Limit("k") {
+		t.Error("the raw key shares a bucket with a namespace")
+	}
+	if got := rl.Count(); got != 3 {
+		t.Errorf("Count = %d, want 3", got)
+	}
+	if !user.RemoveEntry("k") || user.RemoveEntry("k") {
+		t.Error("RemoveEntry didn't remove the namespaced key exactly once")
+	}
+	if got := ip.Tokens("k"); got != 0 {
+		t.Errorf("RemoveEntry in another namespace refilled the key to %v tokens", got)
+	}
+}
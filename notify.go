@@ -0,0 +1,30 @@
+package ratelimiter
+
+import (
+	"time"
+)
+
+// notifyBuffer is how many events Notify's channel holds before further
+// events are dropped.
+const notifyBuffer = 64
+
+// Event reports that a key that was limited on its previous request has
+// been allowed again.
+type Event[K comparable] struct {
+	Key  K
+	Time time.Time
+}
+
+// Notify returns a channel that receives an Event whenever a request is
+// allowed for a key whose previous request was limited. Only decisions
+// made after the first call to Notify are tracked, and every call returns
+// the same channel. Events are dropped rather than delivered late if the
+// channel's buffer is full, so Limit never blocks on a slow reader. The
+// channel is never closed.
+func (rl *KeyedRateLimiter[K]) Notify() <-chan Event[K] {
+	rl.notifyOnce.Do(func() {
+		ch := make(chan Event[K], notifyBuffer)
+		rl.events.Store(&ch)
+	})
+	return *rl.events.Load()
+}
@@ -0,0 +1,34 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 1, WithClock(clock))
+	defer rl.Close()
+	events := rl.Notify()
+
+	rl.Limit("k")
+	rl.Limit("k")
+	clock.Advance(time.Second)
+	rl.Limit("k")
+	select {
+	case ev := <-events:
+		if ev.Key != "k" || !ev.Time.Equal(start.Add(time.Second)) {
+			t.Errorf("got %+v, want k recovering at %v", ev, start.Add(time.Second))
+		}
+	default:
+		t.Fatal("no event for the recovered key")
+	}
+
+	clock.Advance(time.Second)
+	rl.Limit("k")
+	select {
+	case ev := <-events:
+		t.Errorf("got %+v for a key that wasn't limited", ev)
+	default:
+	}
+}
@@ -0,0 +1,107 @@
+package ratelimiter
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Observer is notified of the decisions made by Limit and LimitN, for
+// example to feed allow and deny counters into a metrics system. Methods
+// are called outside the limiter's locks, but on the caller's goroutine,
+// so they should return quickly.
+type Observer[K comparable] interface {
+	// OnAllow is called when a request for k is allowed.
+	OnAllow(k K)
+	// OnLimit is called when a request for k is limited.
+	OnLimit(k K)
+}
+
+// observe counts a decision for k at now and reports it to the observer,
+// if there is one.
+func (rl *KeyedRateLimiter[K]) observe(k K, now time.Time, limited bool) {
+	if rl.stats != nil {
+		rl.stats.count(limited)
+	}
+	if !limited && rl.rating.Load() {
+		rl.throughput.record(rl.since(now))
+	}
+	if limited && rl.logger != nil && rl.logger.Enabled(context.Background(), slog.LevelDebug) {
+		rl.logger.Debug("ratelimiter: request limited", "key", k)
+	}
+	if rl.observer == nil {
+		return
+	}
+	if limited {
+		rl.observer.OnLimit(k)
+	} else {
+		rl.observer.OnAllow(k)
+	}
+}
+
+// report tells the observer and the WithWhenAllowed callback about a
+// decision for k made with limiter at now, after recording it on k's
+// entry for WithSeenOnAllow and Notify.
+func (rl *KeyedRateLimiter[K]) report(k K, limiter *rate.Limiter, now time.Time, limited bool) {
+	rl.track(k, now, limited)
+	rl.observe(k, now, limited)
+	if rl.decisions != nil {
+		rl.decisions.add(DecisionRecord[K]{now, k, !limited, tokensAt(limiter, now)})
+	}
+	if !limited && rl.whenAllowed != nil {
+		rl.whenAllowed(k, tokensAt(limiter, now))
+	}
+}
+
+// track records a decision for k on its entry: the last seen time with
+// WithSeenOnAllow, the run of denials with WithPenalty and WithRetryAdvice,
+// the per-key counts with WithStats, and whether it was limited once
+// Notify has been called, sending an Event if k has just recovered.
+func (rl *KeyedRateLimiter[K]) track(k K, now time.Time, limited bool) {
+	events := rl.events.Load()
+	if events == nil && rl.penalty == nil && rl.stats == nil && rl.adviceMax <= 0 && (limited || !rl.seenOnAllow) {
+		return
+	}
+
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	v, exists := sh.entries[k]
+	if !exists {
+		sh.mu.RUnlock()
+		return
+	}
+	if !limited && rl.seenOnAllow {
+		rl.markSeen(v, now)
+	}
+	if v.penalty != nil {
+		v.penalty.record(rl.penalty, now, limited)
+	}
+	if v.stats != nil {
+		v.stats.count(limited)
+	}
+	if rl.adviceMax > 0 {
+		if limited {
+			v.streak.Add(1)
+		} else {
+			v.streak.Store(0)
+		}
+	}
+	recovered := false
+	if events != nil {
+		if limited {
+			v.denied.Store(true)
+		} else {
+			recovered = v.denied.Swap(false)
+		}
+	}
+	sh.mu.RUnlock()
+
+	if recovered {
+		select {
+		case *events <- Event[K]{Key: k, Time: now}:
+		default:
+		}
+	}
+}
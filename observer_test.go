@@ -0,0 +1,51 @@
+package ratelimiter
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// countingObserver tallies the decisions it is told about.
+type countingObserver struct {
+	allowed, limited map[string]int
+}
+
+func newCountingObserver() *countingObserver {
+	return &countingObserver{allowed: map[string]int{}, limited: map[string]int{}}
+}
+
+func (o *countingObserver) OnAllow(k string) { o.allowed[k]++ }
+func (o *countingObserver) OnLimit(k string) { o.limited[k]++ }
+
+func TestObserver(t *testing.T) {
+	obs := newCountingObserver()
+	rl := New(time.Minute, 1, 2, WithObserver[string](obs), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for range 5 {
+		rl.Limit("a")
+	}
+	rl.LimitN("b", 2)
+	rl.LimitN("b", 1)
+	for k, want := range map[string][2]int{"a": {2, 3}, "b": {1, 1}} {
+		if got := [2]int{obs.allowed[k], obs.limited[k]}; got != want {
+			t.Errorf("%s: observed %d allowed and %d limited, want %d and %d", k, got[0], got[1], want[0], want[1])
+		}
+	}
+}
+
+func TestWhenAllowed(t *testing.T) {
+	calls := map[string][]float64{}
+	rl := New(time.Minute, 1, 2, WithWhenAllowed(func(k string, remaining float64) {
+		calls[k] = append(calls[k], remaining)
+	}), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for range 4 {
+		rl.Limit("k")
+	}
+	if got, want := calls["k"], []float64{1, 0}; !slices.Equal(got, want) {
+		t.Errorf("callback called with %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,434 @@
+package ratelimiter
+
+import (
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Option configures optional behaviour of a limiter created with New or
+// NewKeyed.
+type Option func(*options)
+
+// options holds the settings collected from Options before a limiter is
+// built.
+type options struct {
+	expiry     time.Duration
+	jitter     float64
+	rand       *rand.Rand
+	hashSeed   *uint64
+	shards     int
+	clock      Clock
+	global     *rate.Limiter
+	inserts    *rate.Limiter
+	fairWindow time.Duration
+
+	maxWaiters  int
+	maxKeys     int
+	emptyStart  bool
+	seenOnAllow bool
+	seenEvery   time.Duration
+	seenGrain   time.Duration
+	adviceMax   time.Duration
+	logSize     int
+	headroom    float64
+	keySlots    int
+	emptyLimit  *keyLimit
+	rejectEmpty bool
+	logger      *slog.Logger
+	penalty     *penaltyPolicy
+	probation   *probationPolicy
+	stats       *counters
+
+	// Options that depend on the key type are stored untyped and
+	// checked against it by keyedOption.
+	observer    any // Observer[K]
+	whenAllowed any // func(K, float64)
+	onEvict     any // func(K)
+	onEvictMeta any // func(K, any)
+	classify    any // func(K) string
+	flush       any // func(Snapshot[K])
+	tiers       map[string]time.Duration
+}
+
+// keyedOption returns the value v of the option called name as a T, the
+// type it must have for the limiter's key type. It panics if v was built
+// for a different key type.
+func keyedOption[T any](v any, name string) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	t, ok := v.(T)
+	if !ok {
+		panic("ratelimiter: " + name + " key type does not match the limiter's")
+	}
+	return t
+}
+
+// WithExpiry sets how long an entry may go unseen before the cleanup
+// goroutine removes it, independently of how often the goroutine runs. A
+// zero value keeps the default described on New.
+func WithExpiry(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.expiry = d
+		}
+	}
+}
+
+// WithExpiryTiers gives classes of keys expiries of their own, so that
+// short-lived anonymous keys can be reaped aggressively while tenant keys
+// are kept much longer. Cleanup passes each key to classify and removes
+// it once it has gone unseen for the expiry tiers holds for the returned
+// class; keys of classes missing from tiers get the limiter's expiry. The
+// cleanup interval still bounds how soon an entry is removed. classify
+// may be called concurrently and shouldn't block. Its key type must match
+// the limiter's.
+func WithExpiryTiers[K comparable](classify func(k K) string, tiers map[string]time.Duration) Option {
+	tiers = maps.Clone(tiers)
+	return func(o *options) {
+		o.classify = classify
+		o.tiers = tiers
+	}
+}
+
+// WithShards sets the number of shards the entries are spread across.
+// More shards reduce lock contention between keys at the cost of a little
+// memory. A value of zero or less keeps the default of 256.
+func WithShards(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.shards = n
+		}
+	}
+}
+
+// WithClock sets the Clock used for entry timestamps, token accounting and
+// the wait between cleanup passes. It is mainly useful in tests. Wait and
+// WaitN still block on real time.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
+
+// WithObserver registers an Observer that is told about every allow and
+// deny decision. The observer's key type must match the limiter's.
+func WithObserver[K comparable](obs Observer[K]) Option {
+	return func(o *options) {
+		o.observer = obs
+	}
+}
+
+// WithGlobalLimit adds a bucket shared by all keys that Limit, LimitN and
+// Check consult before the per-key bucket, capping the total rate the
+// limiter admits. A request denied by the global bucket doesn't consume a
+// per-key token, and one denied by its key's bucket doesn't consume a
+// global token.
+func WithGlobalLimit(r rate.Limit, burst int) Option {
+	return func(o *options) {
+		o.global = rate.NewLimiter(sanitizeRate(r), burst)
+	}
+}
+
+// WithNewKeyLimit caps how fast the limiter starts tracking new keys, at
+// rate r with bursts of up to burst, so that a flood of unique keys can't
+// make it allocate entries faster than cleanup removes them. Once new keys
+// arrive faster than that, the methods that decide requests, such as
+// Limit, Check, Admit, LimitMany, Middleware and those of a
+// StrategyLimiter, limit requests for further new keys without creating
+// entries for them, while keys already tracked are unaffected. Other
+// methods, such as Wait, Commit and SetKeyLimit, still create entries
+// freely.
+func WithNewKeyLimit(r rate.Limit, burst int) Option {
+	return func(o *options) {
+		o.inserts = rate.NewLimiter(sanitizeRate(r), burst)
+	}
+}
+
+// WithMaxWaiters caps how many callers may be blocked in Wait or WaitN on a
+// single key. Further callers get ErrTooManyWaiters immediately instead of
+// queueing, which protects memory when one key is overloaded. Zero, the
+// default, means no cap.
+func WithMaxWaiters(n int) Option {
+	return func(o *options) {
+		o.maxWaiters = n
+	}
+}
+
+// WithMaxKeys caps the number of keys tracked at once. When a new key
+// would exceed the cap the least recently seen key is evicted first, which
+// bounds memory when clients send many distinct keys between cleanup
+// passes. The cap is split evenly between shards, so a key may be evicted
+// while the limiter as a whole holds fewer than n keys. Tracking recency
+// means existing keys always take the write lock. Zero, the default,
+// means no cap.
+func WithMaxKeys(n int) Option {
+	return func(o *options) {
+		o.maxKeys = n
+	}
+}
+
+// WithWhenAllowed registers f to be called whenever Limit, LimitN or Check
+// allows a request, with the key and the tokens it has left, for example
+// to write an audit log of admissions. Unlike an Observer it is never
+// called for limited requests. f runs outside the limiter's locks on the
+// caller's goroutine. Its key type must match the limiter's.
+func WithWhenAllowed[K comparable](f func(k K, remaining float64)) Option {
+	return func(o *options) {
+		o.whenAllowed = f
+	}
+}
+
+// WithOnEvict registers f to be called with the key of every entry that
+// cleanup removes for being idle, or that RemoveEntry removes, for example
+// to flush its final state elsewhere. Entries pushed out by WithMaxKeys
+// are not reported. f runs after the limiter's locks are released, so it
+// may call back into the limiter. Its key type must match the limiter's.
+func WithOnEvict[K comparable](f func(k K)) Option {
+	return func(o *options) {
+		o.onEvict = f
+	}
+}
+
+// WithFlush registers f to be called by Close with a Snapshot of the
+// limiter's state once the cleanup goroutine has stopped, so that callers
+// can persist it on graceful shutdown and Restore it after a restart.
+// Close doesn't return until f does, and f runs only once however often
+// Close is called. Limiters built by NewStrategy can't be snapshotted and
+// never call f. Its key type must match the limiter's.
+func WithFlush[K comparable](f func(Snapshot[K])) Option {
+	return func(o *options) {
+		o.flush = f
+	}
+}
+
+// WithOnEvictMeta is like WithOnEvict but also passes f the metadata the
+// entry held, set with SetMeta, or nil if there was none. It replaces a
+// WithOnEvict callback. Its key type must match the limiter's.
+func WithOnEvictMeta[K comparable](f func(k K, meta any)) Option {
+	return func(o *options) {
+		o.onEvictMeta = f
+	}
+}
+
+// WithEmptyStart makes new entries start with no tokens, so they have to
+// accumulate them at the configured rate, instead of getting a full burst
+// straight away. This stops clients from getting a fresh burst by
+// rotating keys. An entry recreated after cleanup also starts empty.
+func WithEmptyStart() Option {
+	return func(o *options) {
+		o.emptyStart = true
+	}
+}
+
+// WithJitter randomly varies each wait between cleanup passes by up to
+// fraction of the cleanup interval in either direction, so that limiters
+// created together don't all reap at the same moment. A fraction of 0.1
+// gives waits between 90% and 110% of the interval. Fractions are clamped
+// to [0, 1]; the default is no jitter.
+func WithJitter(fraction float64) Option {
+	return func(o *options) {
+		o.jitter = min(max(fraction, 0), 1)
+	}
+}
+
+// WithFairShare makes keys share the WithGlobalLimit bucket fairly once it
+// runs low, instead of first come first served. Each key is entitled to a
+// share of recent global admissions proportional to its weight, set with
+// SetKeyWeight and 1 by default, and a key that has had more than its
+// share is limited until the others catch up, so heavy clients can't
+// starve quiet ones. window is how far back usage is remembered. It has
+// no effect without WithGlobalLimit.
+func WithFairShare(window time.Duration) Option {
+	return func(o *options) {
+		o.fairWindow = window
+	}
+}
+
+// WithStats makes the limiter count the requests it allows and limits, in
+// total, reported by Stats, and for each key, reported by KeyStats.
+func WithStats() Option {
+	return func(o *options) {
+		o.stats = new(counters)
+	}
+}
+
+// WithSeenOnAllow makes only allowed requests refresh a key's last seen
+// time. By default every request does, so a client that keeps getting
+// limited keeps its entry alive forever; with this option such a key ages
+// out once it has gone the expiry without an allowed request, freeing its
+// memory. Wait, Reserve and the other methods that don't make a decision
+// then no longer refresh the key either.
+func WithSeenOnAllow() Option {
+	return func(o *options) {
+		o.seenOnAllow = true
+	}
+}
+
+// WithPenalty hard-blocks keys that keep hitting their limit: once a key
+// has been limited threshold times in a row, with the run starting no
+// more than window ago, every request for it is limited for cooldown,
+// whatever its bucket holds. An allowed request ends a run. When the
+// cooldown elapses the key starts over with a clean record. Blocked
+// requests don't take tokens and don't extend the block. A threshold
+// below 1 disables the policy.
+func WithPenalty(threshold int, window, cooldown time.Duration) Option {
+	return func(o *options) {
+		if threshold < 1 {
+			o.penalty = nil
+			return
+		}
+		o.penalty = &penaltyPolicy{threshold, window, cooldown}
+	}
+}
+
+// WithProbation puts new keys on probation: for their first age after
+// their entry is created they get rate r and burst instead of the
+// defaults, such as a fraction of the normal limit for unknown clients,
+// and they move up to the normal limit once age has passed, keeping the
+// tokens they have. The switch happens on the key's first request after
+// graduating. Keys with an override or schedule when their entry is
+// created skip probation, and setting one ends it. Since probation is
+// measured from the entry's creation, a key that goes idle for longer
+// than the expiry starts over. An age of zero or less disables it.
+func WithProbation(age time.Duration, r rate.Limit, burst int) Option {
+	return func(o *options) {
+		if age <= 0 {
+			o.probation = nil
+			return
+		}
+		o.probation = &probationPolicy{age, keyLimit{sanitizeRate(r), burst}}
+	}
+}
+
+// WithRetryAdvice makes the limiter count each key's consecutive denials
+// so that GetRetryAdvice can escalate its advice, up to max, for clients
+// that keep getting limited. A max of zero or less disables it.
+func WithRetryAdvice(max time.Duration) Option {
+	return func(o *options) {
+		o.adviceMax = max
+	}
+}
+
+// WithPriorityHeadroom reserves fraction of every bucket's burst for
+// PriorityHigh requests made with LimitPriority: PriorityNormal requests
+// are limited once the bucket holds less than that. Fractions are clamped
+// to [0, 1]; the default of 0 reserves nothing.
+func WithPriorityHeadroom(fraction float64) Option {
+	return func(o *options) {
+		o.headroom = min(max(fraction, 0), 1)
+	}
+}
+
+// WithDecisionLog makes the limiter keep the last size decisions, with
+// their key, time and the tokens left, in a ring buffer read by
+// RecentDecisions and DecisionLog, as an audit trail for debugging. The
+// buffer is allocated up front and never grows, but recording takes a
+// mutex shared by all keys, so it costs throughput on busy limiters.
+// Decisions made without consulting an entry, for allowlisted, blocklisted
+// or refused new keys, aren't recorded. A size of zero or less disables
+// it.
+func WithDecisionLog(size int) Option {
+	return func(o *options) {
+		o.logSize = size
+	}
+}
+
+// WithSeenCoalescing makes the limiter refresh a key's last seen time at
+// most once per d rather than on every request, saving a write to memory
+// shared between cores on hot keys. Entries may then be considered idle up
+// to d early, so d should be small next to the expiry; a second is plenty
+// for the default of three minutes.
+func WithSeenCoalescing(d time.Duration) Option {
+	return func(o *options) {
+		o.seenEvery = d
+	}
+}
+
+// WithSeenGranularity records last seen times truncated to multiples of
+// g, such as a second, instead of to the nanosecond. An entry's time is
+// then only written when it moves into a new multiple, so with millions
+// of busy keys almost every request skips the write, and the shared
+// memory it would dirty. Eviction is correct to within g: an entry may be
+// removed up to g before its expiry has passed, so g should be small next
+// to the expiry. Unlike WithSeenCoalescing the recorded times are aligned,
+// so snapshots and Range report them at the same granularity.
+func WithSeenGranularity(g time.Duration) Option {
+	return func(o *options) {
+		o.seenGrain = g
+	}
+}
+
+// WithKeySlots bounds the memory used for huge keyspaces, such as full
+// URLs or session tokens, by hashing every key into one of n slots and
+// tracking the slots instead, so the limiter never holds more than n
+// entries. The price is precision: keys that hash to the same slot share
+// one bucket, so a busy key can get unrelated keys limited, and the chance
+// of that grows as n shrinks relative to the number of active keys.
+// Overrides, weights and penalties apply to whole slots too, and
+// observers, callbacks, snapshots and LimitMany report slots rather than
+// the keys passed in, except that LimitMany's result is keyed by the
+// caller's keys. It requires string keys; NewKeyed panics otherwise.
+func WithKeySlots(n int) Option {
+	return func(o *options) {
+		o.keySlots = n
+	}
+}
+
+// WithLogger makes the limiter log through logger: the number of entries
+// each cleanup pass evicts at info level, and every limited request at
+// debug level. A nil logger, the default, logs nothing.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithEmptyKeyLimit gives requests with the zero key, such as "" from a
+// key function that couldn't identify the client, a limit of their own:
+// they share one bucket with rate r and burst, as if set by SetKeyLimit.
+// By default the zero key is treated like any other key.
+func WithEmptyKeyLimit(r rate.Limit, burst int) Option {
+	return func(o *options) {
+		o.emptyLimit = &keyLimit{r, burst}
+	}
+}
+
+// WithRejectEmptyKeys makes the limiter limit every request with the zero
+// key, for services that require clients to identify themselves. Wait,
+// Reserve and the other methods that don't decide on a single request
+// still treat it like any other key.
+func WithRejectEmptyKeys() Option {
+	return func(o *options) {
+		o.rejectEmpty = true
+	}
+}
+
+// WithRand makes the limiter draw the random numbers behind WithJitter
+// from r instead of the global source, so tests and simulations can
+// reproduce them. The limiter serializes its use of r, but r mustn't be
+// used elsewhere at the same time.
+func WithRand(r *rand.Rand) Option {
+	return func(o *options) {
+		o.rand = r
+	}
+}
+
+// WithSeed makes everything random about the limiter that can be made
+// reproducible depend only on seed: the jitter, as with WithRand, and the
+// slot WithKeySlots puts each key in, which otherwise differs between
+// limiters. Which shard holds a key is still chosen by a randomly seeded
+// hash, but that only shows in how a WithMaxKeys cap is split.
+func WithSeed(seed uint64) Option {
+	return func(o *options) {
+		o.rand = rand.New(rand.NewPCG(seed, seed))
+		o.hashSeed = &seed
+	}
+}
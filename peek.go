@@ -0,0 +1,44 @@
+package ratelimiter
+
+// Peek reports whether a request for k would be allowed now, without
+// taking a token, creating an entry or telling observers, for callers that
+// only want to count requests that succeed: Peek, do the work, and Commit
+// on success. Nothing is reserved between the two calls, so other
+// requests for k may take the token in the meantime, and several callers
+// that Peek at once can all be told yes. Commit charges regardless, so
+// the bucket then goes into debt and later requests wait longer. Callers
+// that must not overshoot should use Admit instead.
+func (rl *KeyedRateLimiter[K]) Peek(k K) bool {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		return !limited
+	}
+	if limited, decided := rl.preempt(k, now); decided {
+		return !limited
+	}
+	if rl.global != nil && tokensAt(rl.global, now) < 1 {
+		return false
+	}
+	tokens, _, _ := rl.level(k, now)
+	return tokens >= 1
+}
+
+// Commit takes one token from k's bucket, and from the WithGlobalLimit
+// bucket if there is one, for a request allowed by Peek. It charges even
+// if the tokens have gone since, putting the buckets into debt, so every
+// committed request counts against the rate. Allowlisted keys aren't
+// charged.
+func (rl *KeyedRateLimiter[K]) Commit(k K) {
+	k = rl.keyOf(k)
+	if rl.allowlisted(k) {
+		return
+	}
+	now := rl.clock.Now()
+	// The reservations are never used or cancelled: they just leave the
+	// buckets owing the tokens.
+	rl.getEntryAt(k, now).ReserveN(now, 1)
+	if rl.global != nil {
+		rl.global.ReserveN(now, 1)
+	}
+}
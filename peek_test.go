@@ -0,0 +1,36 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeekCommit(t *testing.T) {
+	rl := New(time.Minute, 1, 2, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	// Requests that fail downstream are peeked at but never committed.
+	for range 5 {
+		if !rl.Peek("k") {
+			t.Fatal("Peek refused a request with tokens available")
+		}
+	}
+	if got := rl.Tokens("k"); got != 2 {
+		t.Errorf("Tokens after Peek without Commit = %v, want 2", got)
+	}
+	if got := rl.Count(); got != 0 {
+		t.Errorf("Peek created %d entries", got)
+	}
+
+	for range 2 {
+		rl.Peek("k")
+		rl.Commit("k")
+	}
+	if rl.Peek("k") {
+		t.Error("Peek allowed a request after the committed ones emptied the bucket")
+	}
+	rl.Commit("k")
+	if got := rl.Tokens("k"); got != -1 {
+		t.Errorf("Tokens after committing past empty = %v, want -1", got)
+	}
+}
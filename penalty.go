@@ -0,0 +1,71 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// penaltyPolicy holds the WithPenalty settings.
+type penaltyPolicy struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+}
+
+// penaltyState is one entry's run of consecutive denials and any block it
+// has earned. It has its own mutex because decisions are recorded under
+// the shard's read lock.
+type penaltyState struct {
+	mu      sync.Mutex
+	denials int       // Consecutive denials in the current run.
+	since   time.Time // When the current run began.
+	until   time.Time // The entry is blocked until then.
+}
+
+// blockedFor reports how much longer the entry is blocked at now, or 0 if
+// it isn't.
+func (p *penaltyState) blockedFor(now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return max(p.until.Sub(now), 0)
+}
+
+// record counts a decision made at now, starting a block once the policy's
+// threshold of consecutive denials is reached within its window. Decisions
+// made while blocked don't count.
+func (p *penaltyState) record(pol *penaltyPolicy, now time.Time, limited bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now.Before(p.until) {
+		return
+	}
+	if !limited {
+		p.denials = 0
+		return
+	}
+	if p.denials == 0 || now.Sub(p.since) > pol.window {
+		p.denials = 0
+		p.since = now
+	}
+	p.denials++
+	if p.denials >= pol.threshold {
+		p.until = now.Add(pol.cooldown)
+		p.denials = 0
+	}
+}
+
+// penalized reports how much longer k is blocked by WithPenalty at now, or
+// 0 if it isn't.
+func (rl *KeyedRateLimiter[K]) penalized(k K, now time.Time) time.Duration {
+	if rl.penalty == nil {
+		return 0
+	}
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if v, exists := sh.entries[k]; exists {
+		return v.penalty.blockedFor(now)
+	}
+	return 0
+}
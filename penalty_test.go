@@ -0,0 +1,31 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPenalty(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 1, WithPenalty(3, 10*time.Second, time.Minute), WithClock(clock))
+	defer rl.Close()
+
+	rl.Limit("k")
+	for range 3 {
+		rl.Limit("k")
+	}
+	clock.Advance(5 * time.Second)
+	if !rl.Limit("k") {
+		t.Fatal("repeat offender allowed during its cooldown")
+	}
+	if rl.Tokens("k") != 1 {
+		t.Error("blocked request took a token")
+	}
+	if got := rl.TimeToAvailable("k"); got != 55*time.Second {
+		t.Errorf("TimeToAvailable during the cooldown = %v, want 55s", got)
+	}
+	clock.Advance(55 * time.Second)
+	if rl.Limit("k") {
+		t.Error("key still limited once its cooldown elapsed")
+	}
+}
@@ -0,0 +1,71 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteToReadFrom(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 5, WithClock(clock))
+	defer rl.Close()
+	rl.LimitN("a", 2)
+	rl.SetKeyLimit("b", 2, 10)
+	rl.LimitN("b", 10)
+	clock.Advance(time.Second)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rl.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	restored := New(time.Minute, 1, 5, WithClock(clock))
+	defer restored.Close()
+	if _, err := restored.ReadFrom(f); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b"} {
+		tokens, r, burst := rl.level(k, clock.Now())
+		gotTokens, gotRate, gotBurst := restored.level(k, clock.Now())
+		if gotTokens != tokens || gotRate != r || gotBurst != burst {
+			t.Errorf("%s restored with %v tokens at %v/s and burst %d, want %v at %v/s and %d", k, gotTokens, gotRate, gotBurst, tokens, r, burst)
+		}
+		if first, _ := restored.FirstSeen(k); !first.Equal(start) {
+			t.Errorf("%s restored first seen at %v, want %v", k, first, start)
+		}
+	}
+}
+
+func TestReadFromVersion(t *testing.T) {
+	rl := New(time.Minute, 1, 5)
+	defer rl.Close()
+
+	for _, doc := range []string{`{"entries": []}`, `{"version": 2, "entries": []}`, `not json`} {
+		if _, err := rl.ReadFrom(strings.NewReader(doc)); err == nil {
+			t.Errorf("ReadFrom(%s) succeeded", doc)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := rl.ReadFrom(strings.NewReader(`{"version": 1, "entries": [], "future": true}`)); err != nil {
+		t.Errorf("ReadFrom with an unknown field: %v", err)
+	}
+	if _, err := rl.WriteTo(&buf); err != nil || buf.String() != `{"version":1,"entries":[]}` {
+		t.Errorf("WriteTo of no keys wrote %s, %v", buf.String(), err)
+	}
+}
@@ -0,0 +1,47 @@
+package ratelimiter
+
+// Priority ranks requests for LimitPriority.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota // Best-effort traffic, limited first.
+	PriorityHigh                   // Critical traffic that may use the reserved headroom.
+)
+
+// LimitPriority is like Limit but lets critical requests, such as payment
+// callbacks, through after best-effort ones start being limited: with
+// WithPriorityHeadroom a PriorityNormal request is limited if taking its
+// token would leave less than the headroom in k's bucket, while a
+// PriorityHigh request may take the bucket down to empty. Without the
+// option both priorities behave like Limit. Normal requests don't spend
+// SetKeyCredit credit.
+func (rl *KeyedRateLimiter[K]) LimitPriority(k K, p Priority) bool {
+	now := rl.clock.Now()
+	if p >= PriorityHigh || rl.headroom == 0 {
+		return rl.limitAt(k, now, 1)
+	}
+
+	k = rl.keyOf(k)
+	if limited, decided := rl.listed(k, now); decided {
+		return limited
+	}
+	limiter := rl.admitEntryAt(k, now)
+	if limiter == nil {
+		return true
+	}
+	if limited, decided := rl.preempt(k, now); decided {
+		rl.report(k, limiter, now, limited)
+		return limited
+	}
+
+	reserve := rl.headroom * float64(limiter.Burst())
+	allowed := tokensAt(limiter, now)-1 >= reserve && rl.allowN(k, limiter, now, 1)
+	if allowed && tokensAt(limiter, now) < reserve {
+		// Another request took tokens between the check and the take,
+		// so this one would eat into the headroom: hand the token back.
+		rl.refundAt(k, limiter, now, 1)
+		allowed = false
+	}
+	rl.report(k, limiter, now, !allowed)
+	return !allowed
+}
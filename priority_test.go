@@ -0,0 +1,33 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimitPriority(t *testing.T) {
+	rl := New(time.Minute, 1, 10, WithPriorityHeadroom(0.2), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	normal := 0
+	for !rl.LimitPriority("k", PriorityNormal) {
+		normal++
+	}
+	if normal != 8 {
+		t.Errorf("%d normal requests allowed with 20%% headroom on a burst of 10, want 8", normal)
+	}
+	for i := range 2 {
+		if rl.LimitPriority("k", PriorityHigh) {
+			t.Errorf("high priority request %d limited with headroom left", i)
+		}
+	}
+	if !rl.LimitPriority("k", PriorityHigh) {
+		t.Error("high priority request allowed from an empty bucket")
+	}
+
+	plain := New(time.Minute, 1, 2, WithClock(newFakeClock()))
+	defer plain.Close()
+	if plain.LimitPriority("k", PriorityNormal) || plain.LimitPriority("k", PriorityNormal) {
+		t.Error("normal request limited without WithPriorityHeadroom")
+	}
+}
@@ -0,0 +1,28 @@
+package ratelimiter
+
+import "time"
+
+// probationPolicy holds the WithProbation settings.
+type probationPolicy struct {
+	age   time.Duration
+	limit keyLimit
+}
+
+// graduating reports whether v is on probation but has been tracked for
+// long enough at now to leave it. The caller must hold the entry's shard
+// lock, for reading at least.
+func (rl *KeyedRateLimiter[K]) graduating(v *entry[K], now time.Time) bool {
+	return v.probation && rl.since(now)-v.created >= rl.probation.age
+}
+
+// graduateLocked moves v off probation onto its normal limit, if it's due
+// to leave. The caller must hold sh.mu.
+func (rl *KeyedRateLimiter[K]) graduateLocked(sh *shard[K], k K, v *entry[K], now time.Time) {
+	if !rl.graduating(v, now) {
+		return
+	}
+	v.probation = false
+	lim := rl.limitLocked(sh, k, false)
+	v.limiter.SetLimitAt(now, lim.rate)
+	v.limiter.SetBurstAt(now, lim.burst)
+}
@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbation(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Hour, 10, 10, WithProbation(time.Hour, 1, 2), WithClock(clock))
+	defer rl.Close()
+
+	allowed := func(k string) int {
+		n := 0
+		for !rl.Limit(k) {
+			n++
+		}
+		return n
+	}
+	rl.SetKeyLimit("trusted", 10, 10)
+	if got := allowed("trusted"); got != 10 {
+		t.Errorf("%d requests allowed for a key with an override, want 10", got)
+	}
+	if got := allowed("new"); got != 2 {
+		t.Errorf("%d requests allowed for a key on probation, want 2", got)
+	}
+	clock.Advance(time.Second)
+	if got := allowed("new"); got != 1 {
+		t.Errorf("%d requests allowed a second into probation, want 1", got)
+	}
+
+	clock.Advance(time.Hour)
+	// Graduating keeps the tokens, which probation capped at 2.
+	if got := allowed("new"); got != 2 {
+		t.Errorf("%d requests allowed on graduating, want 2", got)
+	}
+	clock.Advance(time.Second)
+	if got := allowed("new"); got != 10 {
+		t.Errorf("%d requests allowed a second after graduating, want 10", got)
+	}
+}
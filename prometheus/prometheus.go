@@ -0,0 +1,76 @@
+// Package prometheus provides a Prometheus collector exposing the state of
+// a ratelimiter limiter, keeping the Prometheus client out of the core
+// package.
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/simplifyd-systems/ratelimiter"
+)
+
+// Option configures a collector created by NewCollector.
+type Option func(*options)
+
+type options struct {
+	labels prometheus.Labels
+}
+
+// WithConstLabels adds labels to every metric the collector exports, so
+// that several limiters can be registered with the same registry.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) {
+		o.labels = labels
+	}
+}
+
+type collector[K comparable] struct {
+	rl       *ratelimiter.KeyedRateLimiter[K]
+	keys     *prometheus.Desc
+	rate     *prometheus.Desc
+	burst    *prometheus.Desc
+	requests *prometheus.Desc
+}
+
+// NewCollector returns a collector exporting rl's Stats: gauges for the
+// number of tracked keys and the default rate and burst, and, if rl was
+// created with ratelimiter.WithStats, a counter of the requests it has
+// allowed and limited.
+func NewCollector[K comparable](rl *ratelimiter.KeyedRateLimiter[K], opts ...Option) prometheus.Collector {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &collector[K]{
+		rl: rl,
+		keys: prometheus.NewDesc("ratelimiter_keys",
+			"Number of keys currently tracked.", nil, o.labels),
+		rate: prometheus.NewDesc("ratelimiter_rate",
+			"Tokens per second added to the bucket of keys without an override.", nil, o.labels),
+		burst: prometheus.NewDesc("ratelimiter_burst",
+			"Bucket size of keys without an override.", nil, o.labels),
+		requests: prometheus.NewDesc("ratelimiter_requests_total",
+			"Requests decided, by whether they were allowed or limited.", []string{"decision"}, o.labels),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector[K]) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.keys
+	ch <- c.rate
+	ch <- c.burst
+	ch <- c.requests
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector[K]) Collect(ch chan<- prometheus.Metric) {
+	st := c.rl.Stats()
+	ch <- prometheus.MustNewConstMetric(c.keys, prometheus.GaugeValue, float64(st.Keys))
+	ch <- prometheus.MustNewConstMetric(c.rate, prometheus.GaugeValue, float64(st.Rate))
+	ch <- prometheus.MustNewConstMetric(c.burst, prometheus.GaugeValue, float64(st.Burst))
+	if st.Counted {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(st.Allowed), "allowed")
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(st.Limited), "limited")
+	}
+}
@@ -0,0 +1,57 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/simplifyd-systems/ratelimiter"
+)
+
+func TestCollector(t *testing.T) {
+	rl := ratelimiter.New(time.Minute, 2, 3, ratelimiter.WithStats())
+	defer rl.Close()
+	for range 4 {
+		rl.Limit("k")
+	}
+	rl.Limit("other")
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(rl, WithConstLabels(prometheus.Labels{"limiter": "api"})))
+	want := `
+# HELP ratelimiter_burst Bucket size of keys without an override.
+# TYPE ratelimiter_burst gauge
+ratelimiter_burst{limiter="api"} 3
+# HELP ratelimiter_keys Number of keys currently tracked.
+# TYPE ratelimiter_keys gauge
+ratelimiter_keys{limiter="api"} 2
+# HELP ratelimiter_rate Tokens per second added to the bucket of keys without an override.
+# TYPE ratelimiter_rate gauge
+ratelimiter_rate{limiter="api"} 2
+# HELP ratelimiter_requests_total Requests decided, by whether they were allowed or limited.
+# TYPE ratelimiter_requests_total counter
+ratelimiter_requests_total{decision="allowed",limiter="api"} 4
+ratelimiter_requests_total{decision="limited",limiter="api"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorWithoutStats(t *testing.T) {
+	rl := ratelimiter.New(time.Minute, 2, 3)
+	defer rl.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(rl))
+	n, err := testutil.GatherAndCount(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("gathered %d metrics, want the 3 gauges", n)
+	}
+}
@@ -1,86 +1,1738 @@
 package ratelimiter
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
-type RateLimiter struct {
-	entries        map[string]*entry // Create a map to hold the rate limiters for each entry and a mutex.
-	mu             sync.Mutex
-	ratePerSec     rate.Limit
-	burstPerPeriod int
+// defaultExpiry is the shortest time an entry may go unseen before it is
+// removed when no WithExpiry option is given.
+const defaultExpiry = 3 * time.Minute
+
+// defaultShards is the number of shards the entries are spread across
+// when no WithShards option is given.
+const defaultShards = 256
+
+// ErrTooManyWaiters is returned by Wait and WaitN when the key already has
+// the maximum number of callers waiting set with WithMaxWaiters.
+var ErrTooManyWaiters = errors.New("ratelimiter: too many waiters for key")
+
+// ErrClosed is returned by Wait, WaitN and WaitUntilAllowed once
+// DrainAndClose has been called, and by Healthy once the limiter is
+// closed.
+var ErrClosed = errors.New("ratelimiter: limiter is closed")
+
+// ErrWaitTimeout is returned by WaitUntilAllowed when a token wouldn't be
+// available within the maximum wait.
+var ErrWaitTimeout = errors.New("ratelimiter: wait would exceed the maximum")
+
+// ErrBlocked is returned by Wait, WaitN and WaitUntilAllowed for a key on
+// the blocklist, which no amount of waiting would let through.
+var ErrBlocked = errors.New("ratelimiter: key is blocked")
+
+// RateLimiter is the common KeyedRateLimiter keyed by strings such as IP
+// addresses or API keys.
+type RateLimiter = KeyedRateLimiter[string]
+
+// KeyedRateLimiter tracks a token bucket per key of type K. Using a
+// comparable struct as K lets callers limit by composite keys without
+// formatting them into strings.
+type KeyedRateLimiter[K comparable] struct {
+	shards        []*shard[K]              // Entries are spread across shards to reduce lock contention.
+	seed          maphash.Seed             // Seeds the hash that picks a key's shard.
+	defaults      atomic.Pointer[keyLimit] // The rate and burst of keys without an override.
+	defaultsMu    sync.Mutex               // Serializes SetDefaults.
+	boost         boost                    // The BoostAll multiplier and its timer.
+	boosted       atomic.Uint64            // The BoostAll multiplier as float64 bits, 0 for none, read without boost.mu.
+	expiry        time.Duration            // How long an entry may go unseen before cleanup removes it.
+	classify      func(k K) string         // Picks a key's expiry tier, nil unless WithExpiryTiers is used.
+	tiers         map[string]time.Duration // Expiries of the classes classify returns.
+	jitter        float64                  // Fraction by which the wait between cleanup passes varies.
+	rand          *rand.Rand               // Source of the jitter set with WithRand or WithSeed, nil for the global one.
+	randMu        sync.Mutex
+	clock         Clock
+	epoch         time.Time        // When the limiter was created, the origin of entries' last seen times.
+	global        *rate.Limiter    // Shared by all keys, nil unless WithGlobalLimit is used.
+	globalBurst   int              // The global bucket's configured burst.
+	inserts       *rate.Limiter    // Paces the creation of entries, nil unless WithNewKeyLimit is used.
+	fair          *fairShare[K]    // Shares the global bucket between keys, nil unless WithFairShare is used.
+	allow         allowlist[K]     // Keys and networks that bypass limiting.
+	block         blocklist[K]     // Keys that are always limited.
+	maxWaiters    int              // Most callers that may wait on one key, 0 for no limit.
+	emptyStart    bool             // New entries start with no tokens rather than a full burst.
+	seenOnAllow   bool             // Only allowed requests refresh an entry's last seen time.
+	rejectEmpty   bool             // Requests with the zero key are always limited.
+	emptyLimit    *keyLimit        // The zero key's limit, nil unless WithEmptyKeyLimit is used.
+	seenEvery     time.Duration    // Least time between writes to an entry's last seen time.
+	seenGrain     time.Duration    // Granularity last seen times are truncated to.
+	headroom      float64          // Fraction of the burst reserved for PriorityHigh requests.
+	adviceMax     time.Duration    // Cap on GetRetryAdvice, 0 unless WithRetryAdvice is used.
+	penalty       *penaltyPolicy   // Blocks repeat offenders, nil unless WithPenalty is used.
+	probation     *probationPolicy // Reduced limits for new keys, nil unless WithProbation is used.
+	observer      Observer[K]
+	logger        *slog.Logger    // Logs evictions, and limited requests at debug level, nil for no logging.
+	rating        atomic.Bool     // Set by the first call to GlobalRate.
+	throughput    ewma            // Rate of allowed requests once rating is set.
+	decisions     *decisionLog[K] // Recent decisions, nil unless WithDecisionLog is used.
+	stats         *counters       // Decision counts, nil unless WithStats is used.
+	whenAllowed   func(k K, remaining float64)
+	events        atomic.Pointer[chan Event[K]] // Set by the first call to Notify.
+	notifyOnce    sync.Once
+	credited      atomic.Bool         // Set once SetKeyCredit has been used, so decisions settle credit.
+	disabledKeys  atomic.Int64        // Keys disabled with DisableKey, so decisions only look them up when there are any.
+	scheduledKeys atomic.Int64        // Keys with a schedule, so lookups only check for one when there are any.
+	onEvict       func(k K, meta any) // Set by WithOnEvict or WithOnEvictMeta.
+	flush         func(Snapshot[K])   // Called by Close, nil unless WithFlush is used.
+	strategy      Strategy            // Replaces the token bucket in limiters built by NewKeyedStrategy.
+	slot          func(k K) K         // Maps keys to their WithKeySlots slot, nil unless the option is used.
+	interval      atomic.Int64        // Nanoseconds between cleanup passes, changed by SetCleanupInterval.
+	rescheduled   chan struct{}       // Wakes the cleanup goroutine when the interval changes.
+	drainMu       sync.Mutex
+	draining      bool            // Set by DrainAndClose to turn away new waiters.
+	inFlight      sync.WaitGroup  // Callers blocked in Wait, WaitN or WaitUntilAllowed.
+	halt          context.Context // Cancelled by DrainAndClose to cut waits short.
+	haltWaiters   context.CancelFunc
+	done          chan struct{} // Closed by Close to stop the cleanup goroutine.
+	exited        chan struct{} // Closed by the cleanup goroutine when it returns.
+	closeOnce     sync.Once
 }
 
-type entry struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+// shard holds the entries whose keys hash to it, guarded by its own mutex,
+// so that calls for keys in different shards don't contend.
+type shard[K comparable] struct {
+	entries   map[K]*entry[K] // Create a map to hold the rate limiters for each entry and a mutex.
+	overrides map[K]keyLimit  // Per-key limits set with SetKeyLimit, kept across cleanup.
+	credits   map[K]int       // Per-key credit ceilings set with SetKeyCredit, kept across cleanup.
+	disabled  map[K]struct{}  // Keys disabled with DisableKey, kept across cleanup.
+	schedules map[K]*schedule // Per-key schedules set with SetKeySchedule, kept across cleanup.
+	mu        sync.RWMutex
+
+	// With WithMaxKeys the shard holds at most capacity entries, kept in
+	// a list from most (head) to least (tail) recently seen.
+	capacity   int
+	head, tail *entry[K]
+}
+
+// keyLimit is a rate and burst that replaces the defaults for one key.
+type keyLimit struct {
+	rate  rate.Limit
+	burst int
+}
+
+type entry[K comparable] struct {
+	limiter   *rate.Limiter
+	lastSeen  atomic.Int64  // Nanoseconds since the limiter's epoch, updated without the shard's write lock.
+	created   time.Duration // Offset from the limiter's epoch at which the entry was created.
+	waiters   int           // Callers blocked in WaitN on this entry.
+	counter   counter       // Per-key state of the strategy, instead of limiter.
+	denied    atomic.Bool   // Whether the last decision limited the key, tracked once Notify is called.
+	streak    atomic.Int32  // Consecutive denials, tracked with WithRetryAdvice.
+	penalty   *penaltyState // Consecutive denials and any block, nil unless WithPenalty is used.
+	stats     *counters     // Decisions for this key, nil unless WithStats is used.
+	credit    *creditPool   // Tokens saved beyond the burst, nil unless SetKeyCredit is used for the key.
+	meta      any           // Caller's metadata set with SetMeta.
+	probation bool          // Whether the entry still has the WithProbation limit.
+
+	key        K         // The entry's key, so LRU eviction can delete it.
+	prev, next *entry[K] // Neighbours in the shard's LRU list.
+}
+
+// seen records at, an offset from the limiter's epoch, as the last time
+// the entry was used.
+func (e *entry[K]) seen(at time.Duration) {
+	e.lastSeen.Store(int64(at))
+}
+
+// idle reports how long the entry had gone unused at the offset at. An
+// entry last seen after at, which an injected clock going backwards can
+// cause, counts as just seen.
+func (e *entry[K]) idle(at time.Duration) time.Duration {
+	return max(at-time.Duration(e.lastSeen.Load()), 0)
+}
+
+// since returns t as an offset from the limiter's epoch. Offsets between
+// readings of the real clock use its monotonic time, so stepping the wall
+// clock, say by an NTP correction, doesn't make entries look idle or
+// freshly used.
+func (rl *KeyedRateLimiter[K]) since(t time.Time) time.Duration {
+	return t.Sub(rl.epoch)
+}
+
+// lastSeen returns the time v was last used.
+func (rl *KeyedRateLimiter[K]) lastSeen(v *entry[K]) time.Time {
+	return rl.epoch.Add(time.Duration(v.lastSeen.Load())).Round(0)
+}
+
+// FirstSeen returns when k's entry was created, which is when k was
+// first seen unless the entry has been recreated since after being
+// removed, and whether k is tracked at all. Together with the last seen
+// time reported by Range it shows how long a key has been active.
+func (rl *KeyedRateLimiter[K]) FirstSeen(k K) (time.Time, bool) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	v, exists := sh.entries[k]
+	if !exists {
+		return time.Time{}, false
+	}
+	return rl.firstSeen(v), true
+}
+
+// firstSeen returns the time v was created.
+func (rl *KeyedRateLimiter[K]) firstSeen(v *entry[K]) time.Time {
+	return rl.epoch.Add(v.created).Round(0)
+}
+
+// seenAt returns now as an offset from the epoch to record as a last
+// seen time, truncated to the WithSeenGranularity.
+func (rl *KeyedRateLimiter[K]) seenAt(now time.Time) time.Duration {
+	at := rl.since(now)
+	if rl.seenGrain > 0 {
+		at = at.Truncate(rl.seenGrain)
+	}
+	return at
+}
+
+// markSeen records now as the last time v was used, skipping the write if
+// it wouldn't change the recorded time or that is less than the
+// WithSeenCoalescing threshold before now, so hot keys don't contend on
+// it.
+func (rl *KeyedRateLimiter[K]) markSeen(v *entry[K], now time.Time) {
+	at := rl.seenAt(now)
+	if d := at - time.Duration(v.lastSeen.Load()); d >= 0 && (d < rl.seenEvery || d == 0) {
+		return
+	}
+	v.seen(at)
 }
 
 // Run a background goroutine to remove old entries from the entries map.
 // f = 1/T frequency = 1/Period
-func New(cleanupInterval time.Duration, ratePerSec rate.Limit, burstPerPeriod int) *RateLimiter {
-	rl := &RateLimiter{
-		ratePerSec:     ratePerSec,
-		burstPerPeriod: burstPerPeriod,
+//
+// cleanupInterval only controls how often the goroutine looks for stale
+// entries; how long an entry may go unseen before it is removed is set
+// with WithExpiry. Without it entries expire after 3 minutes, or after
+// cleanupInterval if that is longer. A zero cleanupInterval doesn't start
+// the goroutine, leaving the caller to call Cleanup.
+//
+// A negative or NaN ratePerSec is treated as 0, so each key only gets its
+// burst; this applies to every method and option taking a rate.
+// NewValidated rejects such rates instead.
+func New(cleanupInterval time.Duration, ratePerSec rate.Limit, burstPerPeriod int, opts ...Option) *RateLimiter {
+	return NewKeyed[string](cleanupInterval, ratePerSec, burstPerPeriod, opts...)
+}
+
+// EveryN returns the rate.Limit of n requests per period, such as
+// EveryN(5, time.Minute), for rates too low to write comfortably as
+// requests per second. It returns 0 for a non-positive n and rate.Inf for
+// a non-positive period.
+func EveryN(n int, per time.Duration) rate.Limit {
+	switch {
+	case n <= 0:
+		return 0
+	case per <= 0:
+		return rate.Inf
+	}
+	return rate.Limit(float64(n) / per.Seconds())
+}
+
+// NewKeyed is like New but returns a limiter keyed by K.
+func NewKeyed[K comparable](cleanupInterval time.Duration, ratePerSec rate.Limit, burstPerPeriod int, opts ...Option) *KeyedRateLimiter[K] {
+	o := options{
+		shards: defaultShards,
+		clock:  realClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.expiry == 0 {
+		o.expiry = defaultExpiry
+		if cleanupInterval > o.expiry {
+			o.expiry = cleanupInterval
+		}
+	}
+
+	rl := &KeyedRateLimiter[K]{
+		expiry:      o.expiry,
+		jitter:      o.jitter,
+		rand:        o.rand,
+		clock:       o.clock,
+		epoch:       o.clock.Now(),
+		global:      o.global,
+		globalBurst: globalBurst(o.global),
+		inserts:     o.inserts,
+		fair:        newFairShare[K](o.fairWindow, o.global),
+		maxWaiters:  o.maxWaiters,
+		emptyStart:  o.emptyStart,
+		seenOnAllow: o.seenOnAllow,
+		rejectEmpty: o.rejectEmpty,
+		seenEvery:   o.seenEvery,
+		seenGrain:   o.seenGrain,
+		adviceMax:   o.adviceMax,
+		headroom:    o.headroom,
+		penalty:     o.penalty,
+		probation:   o.probation,
+		stats:       o.stats,
+		logger:      o.logger,
+		shards:      make([]*shard[K], o.shards),
+		seed:        maphash.MakeSeed(),
+		done:        make(chan struct{}),
+		exited:      make(chan struct{}),
+		rescheduled: make(chan struct{}, 1),
+	}
+	rl.defaults.Store(&keyLimit{sanitizeRate(ratePerSec), burstPerPeriod})
+	rl.halt, rl.haltWaiters = context.WithCancel(context.Background())
+	rl.observer = keyedOption[Observer[K]](o.observer, "WithObserver")
+	rl.whenAllowed = keyedOption[func(K, float64)](o.whenAllowed, "WithWhenAllowed")
+	if o.logSize > 0 {
+		rl.decisions = &decisionLog[K]{records: make([]DecisionRecord[K], o.logSize)}
+	}
+	if o.keySlots > 0 {
+		// Name the slots up front so mapping a key doesn't allocate.
+		names := make([]string, o.keySlots)
+		for i := range names {
+			names[i] = strconv.Itoa(i)
+		}
+		hash := func(k string) uint64 { return maphash.String(rl.seed, k) }
+		if o.hashSeed != nil {
+			hash = func(k string) uint64 { return fnv1a(*o.hashSeed, k) }
+		}
+		slot := func(k string) string {
+			return names[hash(k)%uint64(len(names))]
+		}
+		f, ok := any(slot).(func(K) K)
+		if !ok {
+			panic("ratelimiter: WithKeySlots needs string keys")
+		}
+		rl.slot = f
+	}
+	if f := keyedOption[func(K)](o.onEvict, "WithOnEvict"); f != nil {
+		rl.onEvict = func(k K, _ any) { f(k) }
+	}
+	if f := keyedOption[func(K, any)](o.onEvictMeta, "WithOnEvictMeta"); f != nil {
+		rl.onEvict = f
+	}
+	rl.flush = keyedOption[func(Snapshot[K])](o.flush, "WithFlush")
+	rl.classify = keyedOption[func(K) string](o.classify, "WithExpiryTiers")
+	rl.tiers = o.tiers
+	capacity := 0
+	if o.maxKeys > 0 {
+		// Split the cap between the shards, using fewer shards if
+		// needed so each can hold at least one entry.
+		if len(rl.shards) > o.maxKeys {
+			rl.shards = rl.shards[:o.maxKeys]
+		}
+		capacity = o.maxKeys / len(rl.shards)
+	}
+	for i := range rl.shards {
+		rl.shards[i] = &shard[K]{entries: make(map[K]*entry[K]), capacity: capacity}
+	}
+	if o.emptyLimit != nil {
+		rl.emptyLimit = &keyLimit{sanitizeRate(o.emptyLimit.rate), o.emptyLimit.burst}
+		var zero K
+		sh := rl.shardFor(zero)
+		sh.overrides = map[K]keyLimit{zero: *rl.emptyLimit}
+	}
+	if cleanupInterval > 0 {
+		rl.interval.Store(int64(cleanupInterval))
+		go rl.cleanupEntries()
+	} else {
+		close(rl.exited)
 	}
-	rl.entries = make(map[string]*entry)
-	go rl.cleanupEntries(cleanupInterval)
 	return rl
 }
 
-// Every minute check the map for entries that haven't been seen for
-// more than duration and delete the entries.
-func (rl *RateLimiter) cleanupEntries(duration time.Duration) {
+// Every interval check the map for entries that haven't been seen for
+// more than the configured expiry and delete the entries. Returns once
+// Close is called.
+func (rl *KeyedRateLimiter[K]) cleanupEntries() {
+	defer close(rl.exited)
+
 	for {
-		time.Sleep(duration)
+		var wait <-chan time.Time
+		if d := time.Duration(rl.interval.Load()); d > 0 {
+			wait = rl.clock.After(rl.jittered(d))
+		}
+		select {
+		case <-rl.done:
+			return
+		case <-rl.rescheduled:
+			// Start the wait over with the new interval.
+			continue
+		case <-wait:
+		}
+
+		rl.Cleanup()
+	}
+}
+
+// SetCleanupInterval changes how often the background goroutine removes
+// stale entries, for example to reap more aggressively during an
+// incident. The wait in progress is abandoned and the next pass happens d
+// from now. A zero or negative d pauses cleanup until a positive interval
+// is set. It has no effect on limiters created with a zero cleanup
+// interval, which have no goroutine, or after Close. The expiry is left
+// alone.
+func (rl *KeyedRateLimiter[K]) SetCleanupInterval(d time.Duration) {
+	rl.interval.Store(int64(d))
+	select {
+	case rl.rescheduled <- struct{}{}:
+	default:
+		// The goroutine already has a change to pick up.
+	}
+}
+
+// jittered returns d randomly adjusted by up to the WithJitter fraction in
+// either direction.
+func (rl *KeyedRateLimiter[K]) jittered(d time.Duration) time.Duration {
+	if rl.jitter == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + rl.jitter*(2*rl.random()-1)))
+}
+
+// random returns a number in [0, 1) from the WithRand or WithSeed source,
+// or from the global one if neither was used.
+func (rl *KeyedRateLimiter[K]) random() float64 {
+	if rl.rand == nil {
+		return rand.Float64()
+	}
+	rl.randMu.Lock()
+	defer rl.randMu.Unlock()
+	return rl.rand.Float64()
+}
 
-		rl.mu.Lock()
-		for k, v := range rl.entries {
-			if time.Since(v.lastSeen) > 3*time.Minute {
-				delete(rl.entries, k)
+// Cleanup removes the entries that haven't been seen for more than the
+// configured expiry, or that of their tier with WithExpiryTiers. Entries
+// whose bucket is still in debt to a pending reservation are kept until
+// the reservation comes due, so a long wait can't outlive the bucket it
+// was charged to. Idle time is measured with the monotonic clock, so a
+// wall clock step doesn't evict active entries. Cleanup is run
+// periodically by the background goroutine, and is exported for callers
+// that pass a zero cleanup interval to New and schedule reaping
+// themselves.
+func (rl *KeyedRateLimiter[K]) Cleanup() {
+	var evicted []eviction[K]
+	removed := 0
+	now := rl.clock.Now()
+	for _, sh := range rl.shards {
+		sh.mu.Lock()
+		for k, v := range sh.entries {
+			if v.idle(rl.since(now)) > rl.expiryOf(k) && !reserved(v, now) {
+				sh.remove(k)
+				removed++
+				if rl.onEvict != nil {
+					evicted = append(evicted, eviction[K]{k, v.meta})
+				}
 			}
 		}
-		rl.mu.Unlock()
+		sh.mu.Unlock()
+	}
+	rl.expireBlocks(now)
+
+	// Run the callback once the locks are released, so it may call back
+	// into the limiter.
+	for _, e := range evicted {
+		rl.onEvict(e.k, e.meta)
+	}
+	if rl.logger != nil && removed > 0 {
+		rl.logger.Info("ratelimiter: evicted stale entries", "count", removed)
+	}
+}
+
+// expiryOf returns how long k's entry may go unseen before cleanup
+// removes it.
+func (rl *KeyedRateLimiter[K]) expiryOf(k K) time.Duration {
+	if rl.classify != nil {
+		if d, ok := rl.tiers[rl.classify(k)]; ok {
+			return d
+		}
+	}
+	return rl.expiry
+}
+
+// reserved reports whether e's bucket has tokens reserved beyond what it
+// holds at now, or its strategy counter has state it mustn't lose yet.
+func reserved[K comparable](e *entry[K], now time.Time) bool {
+	if c, ok := e.counter.(pendingCounter); ok {
+		return c.pending(now)
+	}
+	return e.limiter != nil && e.limiter.TokensAt(now) < 0
+}
+
+// Close stops the background cleanup goroutine and waits for it to exit.
+// It is safe to call concurrently with any other method: the limiter keeps
+// working normally afterwards, but stale entries are no longer removed.
+// Calling Close more than once is a no-op. With WithFlush, Close passes
+// the limiter's state to the flush function before returning.
+func (rl *KeyedRateLimiter[K]) Close() {
+	rl.closeOnce.Do(func() {
+		close(rl.done)
+		<-rl.exited
+		if rl.flush != nil && rl.strategy == nil {
+			rl.flush(rl.Snapshot())
+		}
+	})
+	<-rl.exited
+}
+
+// keyOf returns the key k is tracked under: k itself, or its slot with
+// WithKeySlots. The zero key is never mapped, so that it keeps the
+// treatment WithEmptyKeyLimit and WithRejectEmptyKeys give it.
+func (rl *KeyedRateLimiter[K]) keyOf(k K) K {
+	var zero K
+	if rl.slot == nil || k == zero {
+		return k
+	}
+	return rl.slot(k)
+}
+
+// fnv1a returns the 64-bit FNV-1a hash of k, starting from a basis mixed
+// with seed. Unlike maphash it can be seeded reproducibly.
+func fnv1a(seed uint64, k string) uint64 {
+	h := uint64(14695981039346656037) ^ seed
+	for i := 0; i < len(k); i++ {
+		h ^= uint64(k[i])
+		h *= 1099511628211
 	}
+	return h
+}
+
+// shardFor returns the shard holding k, chosen by a hash of k.
+func (rl *KeyedRateLimiter[K]) shardFor(k K) *shard[K] {
+	return rl.shards[maphash.Comparable(rl.seed, k)%uint64(len(rl.shards))]
 }
 
 // Retrieve and return the rate limiter for the current entry if it
 // already exists. Otherwise create a new rate limiter and add it to
 // the entries map, using the k as the key.
-func (rl *RateLimiter) getEntry(k string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+func (rl *KeyedRateLimiter[K]) getEntry(k K) *rate.Limiter {
+	return rl.getEntryAt(k, rl.clock.Now())
+}
+
+// getEntryAt is getEntry with now as the time the key was seen.
+func (rl *KeyedRateLimiter[K]) getEntryAt(k K, now time.Time) *rate.Limiter {
+	return rl.entryAt(k, now, false)
+}
+
+// admitEntryAt is like getEntryAt but returns nil, rather than create an
+// entry, for a new key that WithNewKeyLimit refuses.
+func (rl *KeyedRateLimiter[K]) admitEntryAt(k K, now time.Time) *rate.Limiter {
+	return rl.entryAt(k, now, rl.inserts != nil)
+}
 
-	v, exists := rl.entries[k]
+// entryAt implements getEntryAt and, when gated, admitEntryAt.
+func (rl *KeyedRateLimiter[K]) entryAt(k K, now time.Time, gated bool) *rate.Limiter {
+	sh := rl.shardFor(k)
+
+	// Most calls are for keys that already exist, which only need the
+	// read lock since lastSeen is updated atomically. Shards keeping LRU
+	// order have to move the entry, so they always take the write lock, as
+	// do keys whose schedule has moved on to another window or which are
+	// leaving probation.
+	if sh.capacity == 0 {
+		sh.mu.RLock()
+		if v, exists := sh.entries[k]; exists && !rl.scheduleDueLocked(sh, k, now) && !rl.graduating(v, now) {
+			if !rl.seenOnAllow {
+				rl.markSeen(v, now)
+			}
+			limiter := v.limiter
+			sh.mu.RUnlock()
+			return limiter
+		}
+		sh.mu.RUnlock()
+	}
+
+	// Unlocking without defer keeps this path cheap for shards with a
+	// WithMaxKeys cap, which take it on every call.
+	sh.mu.Lock()
+	if gated && rl.refuseLocked(sh, k, now) {
+		sh.mu.Unlock()
+		return nil
+	}
+	limiter := rl.entryLocked(sh, k, now).limiter
+	sh.mu.Unlock()
+	return limiter
+}
+
+// refuseLocked reports whether k is a new key that WithNewKeyLimit
+// refuses an entry at now. The caller must hold sh.mu.
+func (rl *KeyedRateLimiter[K]) refuseLocked(sh *shard[K], k K, now time.Time) bool {
+	if rl.inserts == nil {
+		return false
+	}
+	if _, exists := sh.entries[k]; exists {
+		return false
+	}
+	return !rl.inserts.AllowN(now, 1)
+}
+
+// tryEntryAt is like admitEntryAt but reports false rather than wait for
+// the shard's lock.
+func (rl *KeyedRateLimiter[K]) tryEntryAt(k K, now time.Time) (*rate.Limiter, bool) {
+	sh := rl.shardFor(k)
+
+	if sh.capacity == 0 {
+		if !sh.mu.TryRLock() {
+			return nil, false
+		}
+		if v, exists := sh.entries[k]; exists && !rl.scheduleDueLocked(sh, k, now) && !rl.graduating(v, now) {
+			if !rl.seenOnAllow {
+				rl.markSeen(v, now)
+			}
+			limiter := v.limiter
+			sh.mu.RUnlock()
+			return limiter, true
+		}
+		sh.mu.RUnlock()
+	}
+
+	if !sh.mu.TryLock() {
+		return nil, false
+	}
+	if rl.refuseLocked(sh, k, now) {
+		sh.mu.Unlock()
+		return nil, true
+	}
+	limiter := rl.entryLocked(sh, k, now).limiter
+	sh.mu.Unlock()
+	return limiter, true
+}
+
+// entryLocked is getEntryAt for callers that need the entry itself. The
+// caller must hold sh.mu.
+func (rl *KeyedRateLimiter[K]) entryLocked(sh *shard[K], k K, now time.Time) *entry[K] {
+	rl.followScheduleLocked(sh, k, now)
+	v, exists := sh.entries[k]
 	if !exists {
-		limiter := rate.NewLimiter(rl.ratePerSec, rl.burstPerPeriod)
+		_, overridden := sh.overrides[k]
 		// Include the current time when creating a new entry.
-		rl.entries[k] = &entry{limiter, time.Now()}
-		return limiter
+		v = &entry[K]{key: k, created: rl.since(now)}
+		v.probation = rl.probation != nil && !overridden && rl.strategy == nil
+		lim := rl.limitLocked(sh, k, v.probation)
+		if rl.penalty != nil {
+			v.penalty = new(penaltyState)
+		}
+		if rl.stats != nil {
+			v.stats = new(counters)
+		}
+		if rl.strategy != nil {
+			v.counter = rl.strategy.newCounter()
+		} else {
+			v.limiter = rate.NewLimiter(lim.rate, lim.burst)
+			if rl.emptyStart {
+				primeTokens(v.limiter, now, 0)
+			}
+		}
+		if c, ok := sh.credits[k]; ok && v.limiter != nil {
+			v.credit = &creditPool{max: float64(c), level: tokensAt(v.limiter, now), at: now}
+		}
+		v.seen(rl.seenAt(now))
+		sh.insert(v)
+		return v
 	}
 
+	if v.probation {
+		rl.graduateLocked(sh, k, v, now)
+	}
 	// Update the last seen time for the entry.
-	v.lastSeen = time.Now()
-	return v.limiter
+	if !rl.seenOnAllow {
+		rl.markSeen(v, now)
+	}
+	sh.touch(v)
+	return v
+}
+
+// limitLocked returns the rate and burst k's bucket is configured with:
+// its override if it has one, otherwise the probation limit if probation
+// is set, or the defaults, boosted by BoostAll. The caller must hold
+// sh.mu, for reading at least.
+func (rl *KeyedRateLimiter[K]) limitLocked(sh *shard[K], k K, probation bool) keyLimit {
+	lim := *rl.defaults.Load()
+	if o, ok := sh.overrides[k]; ok {
+		lim = o
+	} else if probation {
+		lim = rl.probation.limit
+	}
+	return rl.boosting(lim)
+}
+
+// dropOverrideLocked removes k's override, except that the zero key goes
+// back to its WithEmptyKeyLimit limit, which is kept as an override. The
+// caller must hold sh.mu.
+func (rl *KeyedRateLimiter[K]) dropOverrideLocked(sh *shard[K], k K) {
+	var zero K
+	if k == zero && rl.emptyLimit != nil {
+		sh.overrides[k] = *rl.emptyLimit
+		return
+	}
+	delete(sh.overrides, k)
+}
+
+// globalBurst returns the burst global was created with, or 0 if it's nil.
+func globalBurst(global *rate.Limiter) int {
+	if global == nil {
+		return 0
+	}
+	return global.Burst()
 }
 
 // Limit func
 // returns true if we should limit, false otherwise
-func (rl *RateLimiter) Limit(k string) bool {
+func (rl *KeyedRateLimiter[K]) Limit(k K) bool {
+	return rl.limitAt(k, rl.clock.Now(), 1)
+}
+
+// LimitCtx is like Limit but returns true straight away, without touching
+// k's bucket, if ctx is already cancelled or past its deadline, so no
+// token is spent on a request the client has abandoned.
+func (rl *KeyedRateLimiter[K]) LimitCtx(ctx context.Context, k K) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	return rl.Limit(k)
+}
+
+// LimitAt is like Limit but decides as if the request were made at t
+// rather than now, for replaying recorded traffic. t is also recorded as
+// the time k was last seen. Times earlier than a previous request for k
+// are treated as the time of that request.
+func (rl *KeyedRateLimiter[K]) LimitAt(k K, t time.Time) bool {
+	return rl.limitAt(k, t, 1)
+}
+
+// limitAt decides whether n tokens for k are allowed at now.
+func (rl *KeyedRateLimiter[K]) limitAt(k K, now time.Time, n int) bool {
+	k = rl.keyOf(k)
+	if limited, decided := rl.listed(k, now); decided {
+		return limited
+	}
 	// Call the getEntry function to retreive the rate limiter for
 	// the current entry.
+	limiter := rl.admitEntryAt(k, now)
+	if limiter == nil {
+		return true
+	}
+	return rl.decide(k, limiter, now, n)
+}
+
+// decide is limitAt once k's limiter has been found.
+func (rl *KeyedRateLimiter[K]) decide(k K, limiter *rate.Limiter, now time.Time, n int) bool {
+	if limited, decided := rl.preempt(k, now); decided {
+		rl.report(k, limiter, now, limited)
+		return limited
+	}
+	allowed := rl.allowN(k, limiter, now, n)
+	if rl.credited.Load() {
+		allowed = rl.settleCredit(k, limiter, now, n, allowed)
+	}
+	rl.report(k, limiter, now, !allowed)
+	return !allowed
+}
+
+// rejected reports whether requests for k are refused outright by
+// WithRejectEmptyKeys.
+func (rl *KeyedRateLimiter[K]) rejected(k K) bool {
+	var zero K
+	return rl.rejectEmpty && k == zero
+}
+
+// preempt reports whether a request for k at now is decided without
+// consulting its bucket, and if so whether it is limited: keys on the
+// blocklist are limited, then keys disabled with DisableKey are allowed,
+// while those refused by WithRejectEmptyKeys or blocked by WithPenalty
+// are limited.
+func (rl *KeyedRateLimiter[K]) preempt(k K, now time.Time) (limited, decided bool) {
+	if !rl.rejectEmpty && rl.penalty == nil && rl.disabledKeys.Load() == 0 && !rl.block.active.Load() {
+		return false, false
+	}
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return rl.preemptLocked(sh, k, now)
+}
+
+// preemptLocked is preempt for callers that hold sh.mu.
+func (rl *KeyedRateLimiter[K]) preemptLocked(sh *shard[K], k K, now time.Time) (limited, decided bool) {
+	if _, ok := rl.blocked(k, now); ok {
+		return true, true
+	}
+	if _, ok := sh.disabled[k]; ok {
+		return false, true
+	}
+	if rl.rejected(k) {
+		return true, true
+	}
+	if v, ok := sh.entries[k]; ok && v.penalty != nil && v.penalty.blockedFor(now) > 0 {
+		return true, true
+	}
+	return false, false
+}
+
+// TryLimit is like Limit but doesn't wait if k's shard is locked by
+// another caller, returning checked false instead so that the caller can
+// decide, for example by letting the request through. Only the wait for
+// the shard is avoided: the buckets' own locks are still taken, but they
+// are only held for a moment.
+func (rl *KeyedRateLimiter[K]) TryLimit(k K) (limited, checked bool) {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		return limited, true
+	}
+	limiter, ok := rl.tryEntryAt(k, now)
+	if !ok {
+		return false, false
+	}
+	if limiter == nil {
+		return true, true
+	}
+	return rl.decide(k, limiter, now, 1), true
+}
+
+// allowN takes n tokens for k from limiter, and from the global bucket if
+// there is one, reporting whether it could. Tokens are only taken when
+// both buckets have them.
+func (rl *KeyedRateLimiter[K]) allowN(k K, limiter *rate.Limiter, now time.Time, n int) bool {
+	if rl.global == nil {
+		return limiter.AllowN(now, n)
+	}
+	if rl.fair != nil && !rl.fair.admit(k, now, tokensAt(rl.global, now) < float64(rl.global.Burst())/2) {
+		return false
+	}
+
+	g, ok := takeNow(rl.global, now, n)
+	if !ok {
+		return false
+	}
+	if !limiter.AllowN(now, n) {
+		g.CancelAt(now)
+		return false
+	}
+	if rl.fair != nil {
+		rl.fair.record(k, now, n)
+	}
+	return true
+}
+
+// takeNow takes n tokens from limiter if it has them at now, returning
+// the reservation so the caller can hand them back.
+func takeNow(limiter *rate.Limiter, now time.Time, n int) (*rate.Reservation, bool) {
+	r := limiter.ReserveN(now, n)
+	if !r.OK() {
+		return nil, false
+	}
+	if r.DelayFrom(now) > 0 {
+		r.CancelAt(now)
+		return nil, false
+	}
+	return r, true
+}
+
+// LimitHierarchical is like Limit for a key nested under a parent, such as
+// an API key belonging to a user. The request is only allowed if both the
+// parent's and the child's buckets have a token, and then takes one from
+// each, so exhausting the parent limits all of its children. The parent's
+// token isn't taken when the child is limited. Blocking the parent with
+// BlockKey limits all of its children too, while allowlisting it leaves
+// them limited only by their own buckets.
+func (rl *KeyedRateLimiter[K]) LimitHierarchical(child, parent K) bool {
+	child, parent = rl.keyOf(child), rl.keyOf(parent)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(child, now); decided {
+		return limited
+	}
+	parentLimited, parentListed := rl.listed(parent, now)
+	var pl *rate.Limiter
+	if !parentListed {
+		if pl = rl.admitEntryAt(parent, now); pl == nil {
+			return true
+		}
+	}
+	cl := rl.admitEntryAt(child, now)
+	if cl == nil {
+		return true
+	}
+
+	limited, decided := rl.preempt(child, now)
+	if parentLimited && !limited {
+		limited, decided = true, true
+	}
+	if decided {
+		// Neither bucket is charged.
+	} else if pl == nil {
+		// The parent is allowlisted, so only the child's bucket counts.
+		limited = !rl.allowN(child, cl, now, 1)
+	} else if p, ok := takeNow(pl, now, 1); !ok {
+		limited = true
+	} else {
+		limited = !rl.allowN(child, cl, now, 1)
+		if limited {
+			p.CancelAt(now)
+		}
+	}
+	rl.report(child, cl, now, limited)
+	return limited
+}
+
+// LimitN is like Limit but charges n tokens instead of one, for requests
+// that cost more than a single unit. It returns true if we should limit.
+// A request for more tokens than the burst is always limited, and a
+// negative n is charged as 0, as with LimitFunc, so it can't hand tokens
+// back.
+func (rl *KeyedRateLimiter[K]) LimitN(k K, n int) bool {
+	return rl.limitAt(k, rl.clock.Now(), max(n, 0))
+}
+
+// LimitFunc is like LimitN but calls cost for the number of tokens, so
+// that the weight of a request can be worked out lazily, for example from
+// a payload size only known once parsing has started. cost is called at
+// most once, outside the limiter's locks, and not at all for keys on the
+// blocklist or allowlist or new keys refused by WithNewKeyLimit. A
+// negative cost is charged as 0, so it can't hand tokens back.
+func (rl *KeyedRateLimiter[K]) LimitFunc(k K, cost func() int) bool {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		return limited
+	}
+	limiter := rl.admitEntryAt(k, now)
+	if limiter == nil {
+		return true
+	}
+	return rl.decide(k, limiter, now, max(cost(), 0))
+}
+
+// AllowUpTo is like LimitN but grants as many of the n tokens as k's
+// bucket holds rather than all or nothing, for batches that may be
+// partly processed. It returns the number of tokens taken, which is 0 if
+// the request should be limited. A request for no tokens, n of zero or
+// less, returns 0 without being decided or reported.
+func (rl *KeyedRateLimiter[K]) AllowUpTo(k K, n int) int {
+	if n <= 0 {
+		return 0
+	}
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		if limited {
+			return 0
+		}
+		return n
+	}
+	limiter := rl.admitEntryAt(k, now)
+	if limiter == nil {
+		return 0
+	}
+
+	granted := 0
+	if limited, decided := rl.preempt(k, now); decided {
+		if !limited {
+			granted = n
+		}
+	} else {
+		level := func() int {
+			avail := tokensAt(limiter, now)
+			if rl.global != nil {
+				avail = min(avail, tokensAt(rl.global, now))
+			}
+			return int(max(avail, 0))
+		}
+		for g := min(n, level()); g > 0; g = min(n, level()) {
+			if rl.allowN(k, limiter, now, g) {
+				granted = g
+				break
+			}
+			// Retry with what is left if other callers took tokens in
+			// the meantime. Otherwise the tokens were refused for
+			// another reason, such as fair sharing.
+			if level() >= g {
+				break
+			}
+		}
+	}
+	rl.report(k, limiter, now, granted == 0)
+	return granted
+}
+
+// LimitWithPenalty is like Limit, but a limited request also charges k
+// penalty extra tokens, putting its bucket further into debt, so that
+// clients which keep retrying, such as password guessers, wait longer and
+// longer to be let through. The check and the charge happen under k's
+// shard lock, so no other request for k can slip in between. Penalties
+// above the burst are charged as the burst. Unlike WithPenalty, which
+// blocks keys outright, it only slows their bucket's recovery.
+func (rl *KeyedRateLimiter[K]) LimitWithPenalty(k K, penalty int) bool {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		return limited
+	}
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	if rl.refuseLocked(sh, k, now) {
+		sh.mu.Unlock()
+		return true
+	}
+	// Reset may replace the entry's limiter once the lock is released.
+	limiter := rl.entryLocked(sh, k, now).limiter
+	limited, decided := rl.preemptLocked(sh, k, now)
+	if !decided {
+		limited = !rl.allowN(k, limiter, now, 1)
+	}
+	if !decided && limited && penalty > 0 {
+		// The reservation is never used or cancelled: it just leaves
+		// the bucket owing the tokens.
+		limiter.ReserveN(now, min(penalty, limiter.Burst()))
+	}
+	sh.mu.Unlock()
+
+	rl.report(k, limiter, now, limited)
+	return limited
+}
+
+// LimitMany is like calling Limit for each of keys, but locks each shard
+// once rather than once per key. The returned map holds whether each key
+// should be limited. A key listed more than once is charged each time and
+// reports the last decision.
+func (rl *KeyedRateLimiter[K]) LimitMany(keys []K) map[K]bool {
+	// Decisions are made for the mapped key but returned under the key
+	// the caller passed.
+	type request struct{ k, key K }
+	byShard := make(map[*shard[K]][]request)
+	for _, key := range keys {
+		k := rl.keyOf(key)
+		sh := rl.shardFor(k)
+		byShard[sh] = append(byShard[sh], request{k, key})
+	}
+
+	type decision struct {
+		request
+		limiter *rate.Limiter
+		limited bool
+	}
+	decisions := make([]decision, 0, len(keys))
+	now := rl.clock.Now()
+	for sh, reqs := range byShard {
+		sh.mu.Lock()
+		for _, req := range reqs {
+			if limited, decided := rl.listed(req.k, now); decided {
+				decisions = append(decisions, decision{req, nil, limited})
+				continue
+			}
+			if rl.refuseLocked(sh, req.k, now) {
+				decisions = append(decisions, decision{req, nil, true})
+				continue
+			}
+			v := rl.entryLocked(sh, req.k, now)
+			limited, decided := rl.preemptLocked(sh, req.k, now)
+			if !decided {
+				limited = !rl.allowN(req.k, v.limiter, now, 1)
+			}
+			decisions = append(decisions, decision{req, v.limiter, limited})
+		}
+		sh.mu.Unlock()
+	}
+
+	res := make(map[K]bool, len(keys))
+	for _, d := range decisions {
+		// As with Limit, listed keys and refused new keys aren't
+		// reported.
+		if d.limiter != nil {
+			rl.report(d.k, d.limiter, now, d.limited)
+		}
+		res[d.key] = d.limited
+	}
+	return res
+}
+
+// LimitAll is like Limit for a request that counts against every one of
+// keys, such as a transaction spanning several rate dimensions: it is
+// allowed only if each key has a token, and then takes one from each, but
+// if any key limits it, the tokens already taken from the others are
+// handed back, so a limited request costs nothing. A key listed more than
+// once needs a token for each time. It builds an All rule of On rules.
+func (rl *KeyedRateLimiter[K]) LimitAll(keys []K) bool {
+	rules := make([]Rule, len(keys))
+	for i, k := range keys {
+		rules[i] = On(rl, k)
+	}
+	return All(rules...).Limit()
+}
+
+// LimitResult describes a decision made by Check, with the details needed
+// to render rate limit headers such as X-RateLimit-Remaining.
+type LimitResult struct {
+	Allowed    bool          // Whether the request may proceed.
+	Remaining  int           // Whole tokens left after this request.
+	Limit      int           // The key's burst, the most tokens it can hold.
+	RetryAfter time.Duration // How long until a token is available, when not allowed.
+}
+
+// Check is like Limit but returns the full outcome of the decision rather
+// than just whether to limit.
+func (rl *KeyedRateLimiter[K]) Check(k K) LimitResult {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if d, ok := rl.blocked(k, now); ok {
+		// The key's entry isn't consulted, so report the defaults.
+		return LimitResult{Limit: rl.Burst(), RetryAfter: d}
+	}
+	if rl.allowlisted(k) {
+		return LimitResult{Allowed: true, Remaining: rl.Burst(), Limit: rl.Burst()}
+	}
+	limiter := rl.admitEntryAt(k, now)
+	if limiter == nil {
+		return LimitResult{Limit: rl.Burst(), RetryAfter: delayAt(rl.inserts, now)}
+	}
+
+	res := LimitResult{
+		Allowed: !rl.decide(k, limiter, now, 1),
+		Limit:   limiter.Burst(),
+	}
+	if t := tokensAt(limiter, now); t > 0 {
+		res.Remaining = int(t)
+	}
+	if !res.Allowed {
+		res.RetryAfter = max(delayAt(limiter, now), rl.penalized(k, now))
+	}
+	return res
+}
+
+// Decision is the outcome of LimitSoft.
+type Decision int
+
+const (
+	Allowed          Decision = iota // The request may proceed.
+	AllowedNearLimit                 // The request may proceed, but k is close to being limited.
+	Denied                           // The request should be limited.
+)
+
+// String returns the name of the decision.
+func (d Decision) String() string {
+	switch d {
+	case Allowed:
+		return "Allowed"
+	case AllowedNearLimit:
+		return "AllowedNearLimit"
+	case Denied:
+		return "Denied"
+	}
+	return "Decision(" + strconv.Itoa(int(d)) + ")"
+}
+
+// LimitSoft is like Limit but also warns when k is running low, so callers
+// can tell users they are about to be limited. A request that is allowed
+// is AllowedNearLimit if the whole tokens it leaves in k's bucket are at
+// most threshold of its burst, so a threshold of 0.2 warns once 80% has
+// been used.
+func (rl *KeyedRateLimiter[K]) LimitSoft(k K, threshold float64) Decision {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		if limited {
+			return Denied
+		}
+		return Allowed
+	}
+	limiter := rl.admitEntryAt(k, now)
+	if limiter == nil || rl.decide(k, limiter, now, 1) {
+		return Denied
+	}
+	if rl.disabledKey(k) {
+		return Allowed
+	}
+	if math.Floor(tokensAt(limiter, now)) <= threshold*float64(limiter.Burst()) {
+		return AllowedNearLimit
+	}
+	return Allowed
+}
+
+// Wait blocks until a token is available for k or ctx is done. It returns
+// the context's error if ctx is cancelled or its deadline would be
+// exceeded before a token becomes available.
+func (rl *KeyedRateLimiter[K]) Wait(ctx context.Context, k K) error {
+	return rl.WaitN(ctx, k, 1)
+}
+
+// WaitN is like Wait but waits for n tokens, from k's bucket and from the
+// WithGlobalLimit bucket if there is one. Allowlisted keys return nil at
+// once and blocked ones ErrBlocked. It returns an error immediately if n
+// exceeds the burst, or ErrTooManyWaiters if the limit set with
+// WithMaxWaiters has been reached for k.
+func (rl *KeyedRateLimiter[K]) WaitN(ctx context.Context, k K, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	k = rl.keyOf(k)
+	if limited, decided := rl.listed(k, rl.clock.Now()); decided {
+		if limited {
+			return ErrBlocked
+		}
+		return nil
+	}
+	ctx, limiter, leave, err := rl.join(ctx, k)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	now := rl.clock.Now()
+	d, cancel, ok := rl.reserveN(limiter, now, n)
+	if !ok {
+		return fmt.Errorf("ratelimiter: WaitN(n=%d) exceeds the burst", n)
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		cancel()
+		return context.DeadlineExceeded
+	}
+	return rl.sleep(ctx, d, cancel)
+}
+
+// WaitUntilAllowed is like Wait but gives up once it has waited maxWait,
+// for callers that are prepared to block only so long. If a token won't
+// be available within maxWait it returns ErrWaitTimeout straight away
+// rather than sleeping in vain, without taking the token; this is also
+// the case if k's burst is zero. It returns the context's error if ctx is
+// done first. Like WaitN it also waits for the global bucket and consults
+// the allowlist and blocklist.
+func (rl *KeyedRateLimiter[K]) WaitUntilAllowed(ctx context.Context, k K, maxWait time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	k = rl.keyOf(k)
+	if limited, decided := rl.listed(k, rl.clock.Now()); decided {
+		if limited {
+			return ErrBlocked
+		}
+		return nil
+	}
+	ctx, limiter, leave, err := rl.join(ctx, k)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	d, cancel, ok := rl.reserveN(limiter, rl.clock.Now(), 1)
+	if !ok {
+		return ErrWaitTimeout
+	}
+	if d > maxWait {
+		cancel()
+		return ErrWaitTimeout
+	}
+	return rl.sleep(ctx, d, cancel)
+}
+
+// reserveN reserves n tokens at now from limiter, and from the global
+// bucket if there is one, returning how long until both have them and a
+// function that hands them back. It reports false, reserving nothing, if
+// n exceeds either bucket's burst.
+func (rl *KeyedRateLimiter[K]) reserveN(limiter *rate.Limiter, now time.Time, n int) (time.Duration, func(), bool) {
+	r := limiter.ReserveN(now, n)
+	if !r.OK() {
+		return 0, nil, false
+	}
+	if rl.global == nil {
+		return r.DelayFrom(now), func() { r.CancelAt(rl.clock.Now()) }, true
+	}
+	g := rl.global.ReserveN(now, n)
+	if !g.OK() {
+		r.CancelAt(now)
+		return 0, nil, false
+	}
+	cancel := func() {
+		t := rl.clock.Now()
+		r.CancelAt(t)
+		g.CancelAt(t)
+	}
+	return max(r.DelayFrom(now), g.DelayFrom(now)), cancel, true
+}
+
+// sleep waits d for a reservation to become usable, calling cancel to
+// hand it back and returning the context's error if ctx is done first.
+func (rl *KeyedRateLimiter[K]) sleep(ctx context.Context, d time.Duration, cancel func()) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+// join registers the caller as waiting on k's entry, returning a context
+// derived from ctx that DrainAndClose cancels, the entry's limiter and a
+// function to call once done waiting. It returns ErrTooManyWaiters if the
+// limit set with WithMaxWaiters has been reached, and ErrClosed once
+// DrainAndClose has been called.
+func (rl *KeyedRateLimiter[K]) join(ctx context.Context, k K) (context.Context, *rate.Limiter, func(), error) {
+	rl.drainMu.Lock()
+	if rl.draining {
+		rl.drainMu.Unlock()
+		return nil, nil, nil, ErrClosed
+	}
+	rl.inFlight.Add(1)
+	rl.drainMu.Unlock()
+
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	v := rl.entryLocked(sh, k, rl.clock.Now())
+	if rl.maxWaiters > 0 && v.waiters >= rl.maxWaiters {
+		sh.mu.Unlock()
+		rl.inFlight.Done()
+		return nil, nil, nil, ErrTooManyWaiters
+	}
+	v.waiters++
+	limiter := v.limiter
+	sh.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(rl.halt, cancel)
+	leave := func() {
+		stop()
+		cancel()
+		sh.mu.Lock()
+		v.waiters--
+		sh.mu.Unlock()
+		rl.inFlight.Done()
+	}
+	return ctx, limiter, leave, nil
+}
+
+// Reserve returns a reservation for one token for k. The caller can
+// inspect Delay() to learn how long until the request may proceed, for
+// example to fill a Retry-After header, and must either wait that long
+// or call Cancel() to hand the token back. While a reservation is held it
+// counts against the rate for every other caller of the same key.
+//
+// A reservation stays tied to the bucket it was made on. If k's entry is
+// removed by RemoveEntry, RemoveEntries or ClearAll, the reservation
+// remains safe to use, and cancelling it returns the tokens to the
+// removed bucket, where they have no effect; the entry created for k's
+// next request starts afresh and doesn't know about the reservation.
+// Cleanup never removes an entry while a reservation on it is pending.
+func (rl *KeyedRateLimiter[K]) Reserve(k K) *rate.Reservation {
+	return rl.ReserveN(k, 1)
+}
+
+// ReserveN is like Reserve but reserves n tokens. If n exceeds the burst
+// the reservation is not OK and Delay reports rate.InfDuration.
+func (rl *KeyedRateLimiter[K]) ReserveN(k K, n int) *rate.Reservation {
+	k = rl.keyOf(k)
 	limiter := rl.getEntry(k)
-	return !limiter.Allow()
+	return limiter.ReserveN(rl.clock.Now(), n)
+}
+
+// SetKeyLimit overrides the rate and burst for a single key, for example
+// to give premium customers a higher limit. An existing entry is updated in
+// place, keeping its accumulated tokens; otherwise the override is used
+// when the entry is created. Overrides survive cleanup of idle entries and
+// are only dropped by RemoveEntry. Setting one replaces any schedule set
+// with SetKeySchedule.
+func (rl *KeyedRateLimiter[K]) SetKeyLimit(k K, r rate.Limit, burst int) {
+	k = rl.keyOf(k)
+	r = sanitizeRate(r)
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.overrides == nil {
+		sh.overrides = make(map[K]keyLimit)
+	}
+	sh.overrides[k] = keyLimit{r, burst}
+	rl.unscheduleLocked(sh, k)
+
+	if v, exists := sh.entries[k]; exists {
+		now := rl.clock.Now()
+		lim := rl.boosting(keyLimit{r, burst})
+		v.limiter.SetLimitAt(now, lim.rate)
+		v.limiter.SetBurstAt(now, lim.burst)
+		v.probation = false
+	}
+}
+
+// RegisterKey is like SetKeyLimit but also creates k's entry straight
+// away, without taking a token, so limits loaded at startup are checked
+// eagerly and the first request for k doesn't pay for the allocation. It
+// returns an error wrapping ErrInvalidConfig, and changes nothing, for a
+// negative or NaN rate or a negative burst. Like any other entry, the
+// registered one is removed by cleanup once idle, but the override is
+// kept.
+func (rl *KeyedRateLimiter[K]) RegisterKey(k K, r rate.Limit, burst int) error {
+	k = rl.keyOf(k)
+	if err := validate(0, r, burst); err != nil {
+		return err
+	}
+
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.overrides == nil {
+		sh.overrides = make(map[K]keyLimit)
+	}
+	sh.overrides[k] = keyLimit{r, burst}
+	rl.unscheduleLocked(sh, k)
+
+	now := rl.clock.Now()
+	if v, exists := sh.entries[k]; exists {
+		lim := rl.boosting(keyLimit{r, burst})
+		v.limiter.SetLimitAt(now, lim.rate)
+		v.limiter.SetBurstAt(now, lim.burst)
+		v.probation = false
+		return nil
+	}
+	rl.entryLocked(sh, k, now)
+	return nil
+}
+
+// SetKeyBurst changes the burst of k's existing bucket, creating it if
+// needed, for example to grant one customer extra headroom during a known
+// spike. Unlike SetKeyLimit the change isn't remembered as an override: it
+// lasts until the entry is removed or the limit is set again by
+// SetKeyLimit, SetDefaults or another call to SetKeyBurst.
+func (rl *KeyedRateLimiter[K]) SetKeyBurst(k K, burst int) {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	rl.getEntryAt(k, now).SetBurstAt(now, burst)
+}
+
+// SetKeyRate is like SetKeyBurst but changes the rate of k's bucket.
+func (rl *KeyedRateLimiter[K]) SetKeyRate(k K, r rate.Limit) {
+	k = rl.keyOf(k)
+	r = sanitizeRate(r)
+	now := rl.clock.Now()
+	rl.getEntryAt(k, now).SetLimitAt(now, r)
+}
+
+// Tokens reports how many tokens are currently available for k without
+// consuming any. It does not count against the rate, doesn't update the
+// key's last seen time and doesn't create an entry for an unknown key, for
+// which the tokens a new entry would start with are reported.
+func (rl *KeyedRateLimiter[K]) Tokens(k K) float64 {
+	tokens, _, _ := rl.level(rl.keyOf(k), rl.clock.Now())
+	return tokens
+}
+
+// RoundPolicy selects how Remaining turns a fractional token count into a
+// whole number.
+type RoundPolicy int
+
+const (
+	RoundDown    RoundPolicy = iota // Round towards zero, counting only whole tokens.
+	RoundNearest                    // Round to the nearest whole token, halves up.
+	RoundUp                         // Round up, counting any fraction as a token.
+)
+
+// Remaining is like Tokens but returns a whole number of tokens, rounded
+// as policy says and clamped to between 0 and k's burst, for headers such
+// as X-RateLimit-Remaining. An unknown policy rounds down.
+func (rl *KeyedRateLimiter[K]) Remaining(k K, policy RoundPolicy) int {
+	tokens, _, burst := rl.level(rl.keyOf(k), rl.clock.Now())
+	switch policy {
+	case RoundNearest:
+		tokens = math.Floor(tokens + 0.5)
+	case RoundUp:
+		tokens = math.Ceil(tokens)
+	default:
+		tokens = math.Floor(tokens)
+	}
+	return int(min(max(tokens, 0), float64(burst)))
+}
+
+// TimeToAvailable estimates how long until a token is available for k,
+// for scheduling a retry, returning 0 if one is available now and
+// rate.InfDuration if k's bucket never refills. Like Tokens it changes
+// nothing, so other callers may take the token first.
+func (rl *KeyedRateLimiter[K]) TimeToAvailable(k K) time.Duration {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	tokens, r, _ := rl.level(k, now)
+
+	var d time.Duration
+	switch {
+	case tokens >= 1 || r == rate.Inf:
+	case r <= 0:
+		d = rate.InfDuration
+	default:
+		d = time.Duration((1 - tokens) / float64(r) * float64(time.Second))
+	}
+	return max(d, rl.penalized(k, now))
+}
+
+// level returns the tokens available for k at now, the rate its bucket
+// refills at and its burst, as Tokens describes.
+func (rl *KeyedRateLimiter[K]) level(k K, now time.Time) (float64, rate.Limit, int) {
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	v, exists := sh.entries[k]
+	if !exists {
+		lim := *rl.defaults.Load()
+		if o, ok := sh.overrides[k]; ok {
+			lim = o
+		} else if rl.probation != nil {
+			lim = rl.probation.limit
+		}
+		if sc, ok := sh.schedules[k]; ok && sc.stale(now) {
+			// The override is left for the next call to update.
+			lim = sc.at(now)
+		}
+		lim = rl.boosting(lim)
+		if rl.emptyStart {
+			return 0, lim.rate, lim.burst
+		}
+		return float64(lim.burst), lim.rate, lim.burst
+	}
+	return tokensAt(v.limiter, now), v.limiter.Limit(), v.limiter.Burst()
+}
+
+// retryAfter reports how long until k would be allowed one token, without
+// keeping the reservation used to find out or creating an entry for k. It
+// returns 0 if the delay can't be computed, for example when the burst is
+// zero.
+func (rl *KeyedRateLimiter[K]) retryAfter(k K) time.Duration {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if d, ok := rl.blocked(k, now); ok {
+		return d
+	}
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	v, exists := sh.entries[k]
+	var limiter *rate.Limiter
+	if exists {
+		limiter = v.limiter
+	}
+	sh.mu.RUnlock()
+	if exists {
+		if limiter == nil {
+			return 0
+		}
+		return max(delayAt(limiter, now), rl.penalized(k, now))
+	}
+
+	// An unknown key would start with the bucket level reports.
+	tokens, r, burst := rl.level(k, now)
+	if tokens >= 1 || burst < 1 || r <= 0 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / float64(r) * float64(time.Second))
+}
+
+// GetRetryAdvice suggests how long a client limited on k should wait
+// before retrying. It starts from the time until k has a token, as the
+// Retry-After header set by Middleware does, and with WithRetryAdvice
+// doubles it for every consecutive denial after the first, up to the
+// option's maximum, so clients that keep retrying early are told to back
+// off further instead of all coming back at once. An allowed request
+// resets the escalation. It returns 0 if a token is available now.
+func (rl *KeyedRateLimiter[K]) GetRetryAdvice(k K) time.Duration {
+	wait := rl.retryAfter(k)
+	if wait <= 0 || rl.adviceMax <= 0 {
+		return wait
+	}
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	n := 0
+	if v, exists := sh.entries[k]; exists {
+		n = int(v.streak.Load())
+	}
+	sh.mu.RUnlock()
+
+	d := wait
+	for ; n > 1 && d < rl.adviceMax; n-- {
+		d *= 2
+	}
+	// Never advise retrying before a token is actually available.
+	return max(min(d, rl.adviceMax), wait)
+}
+
+// tokensAt returns the tokens limiter has available at now. A limiter
+// with a rate of 0 grants from its burst, which it lowers as tokens are
+// taken, and no longer updates the level TokensAt reports.
+func tokensAt(limiter *rate.Limiter, now time.Time) float64 {
+	if limiter.Limit() == 0 {
+		return float64(limiter.Burst())
+	}
+	return limiter.TokensAt(now)
+}
+
+// delayAt reports how long after now limiter would grant one token,
+// cancelling the reservation used to find out.
+func delayAt(limiter *rate.Limiter, now time.Time) time.Duration {
+	r := limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return 0
+	}
+	d := r.DelayFrom(now)
+	r.CancelAt(now)
+	return d
+}
+
+// SetDefaults changes the rate and burst of keys without an override, both
+// for entries created from now on and for existing ones, so a control
+// plane can retune limits without a restart. Existing entries keep the
+// tokens they have accumulated, up to the new burst.
+func (rl *KeyedRateLimiter[K]) SetDefaults(r rate.Limit, burst int) {
+	r = sanitizeRate(r)
+	rl.defaultsMu.Lock()
+	defer rl.defaultsMu.Unlock()
+
+	rl.defaults.Store(&keyLimit{r, burst})
+
+	now := rl.clock.Now()
+	lim := rl.boosting(keyLimit{r, burst})
+	for _, sh := range rl.shards {
+		sh.mu.Lock()
+		for k, v := range sh.entries {
+			if _, ok := sh.overrides[k]; ok || v.probation {
+				continue
+			}
+			v.limiter.SetLimitAt(now, lim.rate)
+			v.limiter.SetBurstAt(now, lim.burst)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Rate returns the rate of keys without an override.
+func (rl *KeyedRateLimiter[K]) Rate() rate.Limit {
+	return rl.defaults.Load().rate
+}
+
+// Burst returns the burst of keys without an override.
+func (rl *KeyedRateLimiter[K]) Burst() int {
+	return rl.defaults.Load().burst
+}
+
+// Reset refills k's bucket to its full burst, clearing any accumulated
+// throttling, WithPenalty block and KeyStats counts, for example after a
+// customer upgrades their plan. Unlike RemoveEntry it keeps the key's
+// override and current rate and burst.
+func (rl *KeyedRateLimiter[K]) Reset(k K) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if v, exists := sh.entries[k]; exists {
+		burst := v.limiter.Burst()
+		if v.limiter.Limit() == 0 {
+			// A bucket that doesn't refill keeps its tokens in its
+			// burst, so refill it to the configured one.
+			burst = rl.limitLocked(sh, k, v.probation).burst
+		}
+		v.limiter = rate.NewLimiter(v.limiter.Limit(), burst)
+		if v.penalty != nil {
+			v.penalty = new(penaltyState)
+		}
+		if v.stats != nil {
+			v.stats = new(counters)
+		}
+	}
 }
 
 // This allows our callers remove entries for whatever reason their application
-// or business logic dictates
-func (rl *RateLimiter) RemoveEntry(k string) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// or business logic dictates. Any override set with SetKeyLimit is removed
+// as well, though the zero key keeps its WithEmptyKeyLimit limit. It
+// reports whether k was being tracked. Reservations made on the entry are
+// not cancelled; see Reserve.
+func (rl *KeyedRateLimiter[K]) RemoveEntry(k K) bool {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	var meta any
+	if v, exists := sh.entries[k]; exists {
+		meta = v.meta
+	}
+	existed := sh.remove(k)
+	rl.dropOverrideLocked(sh, k)
+	delete(sh.credits, k)
+	rl.enableLocked(sh, k)
+	rl.unscheduleLocked(sh, k)
+	sh.mu.Unlock()
+
+	if existed && rl.onEvict != nil {
+		rl.onEvict(k, meta)
+	}
+	return existed
+}
+
+// RemoveEntries is like RemoveEntry for a batch of keys, locking each shard
+// once rather than once per key. It returns the number of keys that were
+// being tracked.
+func (rl *KeyedRateLimiter[K]) RemoveEntries(keys []K) int {
+	byShard := make(map[*shard[K]][]K)
+	for _, k := range keys {
+		k = rl.keyOf(k)
+		sh := rl.shardFor(k)
+		byShard[sh] = append(byShard[sh], k)
+	}
 
-	delete(rl.entries, k)
+	var removed []eviction[K]
+	for sh, ks := range byShard {
+		sh.mu.Lock()
+		for _, k := range ks {
+			if v, exists := sh.entries[k]; exists {
+				sh.remove(k)
+				removed = append(removed, eviction[K]{k, v.meta})
+			}
+			rl.dropOverrideLocked(sh, k)
+			delete(sh.credits, k)
+			rl.enableLocked(sh, k)
+			rl.unscheduleLocked(sh, k)
+		}
+		sh.mu.Unlock()
+	}
+
+	if rl.onEvict != nil {
+		for _, e := range removed {
+			rl.onEvict(e.k, e.meta)
+		}
+	}
+	return len(removed)
+}
+
+// ClearAll removes every entry, resetting all throttling state so every
+// key starts again with a full burst. Overrides set with SetKeyLimit are
+// kept, and the WithOnEvict callback is not called.
+func (rl *KeyedRateLimiter[K]) ClearAll() {
+	for _, sh := range rl.shards {
+		sh.mu.Lock()
+		sh.entries = make(map[K]*entry[K])
+		sh.head, sh.tail = nil, nil
+		sh.mu.Unlock()
+	}
+}
+
+// Count returns the number of keys currently tracked. A count that keeps
+// climbing under steady traffic suggests cleanup isn't keeping up.
+func (rl *KeyedRateLimiter[K]) Count() int {
+	n := 0
+	for _, sh := range rl.shards {
+		sh.mu.RLock()
+		n += len(sh.entries)
+		sh.mu.RUnlock()
+	}
+	return n
 }
@@ -0,0 +1,1098 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestCloseStopsCleanup(t *testing.T) {
+	before := runtime.NumGoroutine()
+	limiters := make([]*RateLimiter, 1000)
+	for i := range limiters {
+		limiters[i] = New(time.Minute, 1, 1)
+	}
+	if got := runtime.NumGoroutine(); got < before+len(limiters) {
+		t.Fatalf("%d goroutines running with %d limiters, want at least %d", got, len(limiters), before+len(limiters))
+	}
+	for _, rl := range limiters {
+		rl.Close()
+		rl.Close()
+	}
+	// Close waits for the goroutines to stop, but they may not have
+	// been reaped yet.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > before {
+		t.Errorf("%d goroutines running after Close, want %d", got, before)
+	}
+}
+
+func TestNewKeyLimitEveryMethod(t *testing.T) {
+	methods := map[string]func(rl *RateLimiter, k string) bool{
+		"Limit":             func(rl *RateLimiter, k string) bool { return rl.Limit(k) },
+		"Check":             func(rl *RateLimiter, k string) bool { return !rl.Check(k).Allowed },
+		"LimitWithPenalty":  func(rl *RateLimiter, k string) bool { return rl.LimitWithPenalty(k, 1) },
+		"LimitMany":         func(rl *RateLimiter, k string) bool { return rl.LimitMany([]string{k})[k] },
+		"AllowUpTo":         func(rl *RateLimiter, k string) bool { return rl.AllowUpTo(k, 1) == 0 },
+		"LimitHierarchical": func(rl *RateLimiter, k string) bool { return rl.LimitHierarchical(k, "first") },
+		"LimitAll":          func(rl *RateLimiter, k string) bool { return rl.LimitAll([]string{k}) },
+		"Admit": func(rl *RateLimiter, k string) bool {
+			_, ok := rl.Admit(k)
+			return !ok
+		},
+	}
+	for name, limit := range methods {
+		rl := New(time.Minute, 1, 5, WithNewKeyLimit(1, 1), WithClock(newFakeClock()))
+		rl.Limit("first")
+		if !limit(rl, "second") {
+			t.Errorf("%s allowed a new key past WithNewKeyLimit", name)
+		}
+		if got := rl.Count(); got != 1 {
+			t.Errorf("%s created an entry for a refused key", name)
+		}
+		rl.Close()
+	}
+}
+
+func TestGetRetryAdviceUnknownKey(t *testing.T) {
+	rl := New(time.Minute, 2, 1, WithEmptyStart(), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	if got := rl.GetRetryAdvice("k"); got != 500*time.Millisecond {
+		t.Errorf("GetRetryAdvice for an unknown key = %v, want 500ms", got)
+	}
+	if got := rl.Count(); got != 0 {
+		t.Errorf("GetRetryAdvice created %d entries", got)
+	}
+}
+
+func TestLimitFunc(t *testing.T) {
+	rl := New(time.Minute, 1, 5, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	calls := 0
+	cost := func(n int) func() int {
+		return func() int {
+			calls++
+			return n
+		}
+	}
+	if rl.LimitFunc("k", cost(3)) {
+		t.Fatal("cost of 3 limited with a burst of 5")
+	}
+	if rl.LimitFunc("k", cost(-10)) {
+		t.Error("negative cost limited")
+	}
+	if got := rl.Remaining("k", RoundDown); got != 2 {
+		t.Errorf("Remaining after a negative cost = %d, want 2", got)
+	}
+	if !rl.LimitFunc("k", cost(3)) {
+		t.Error("cost of 3 allowed with 2 tokens left")
+	}
+
+	rl.BlockKey("k", 0)
+	calls = 0
+	rl.LimitFunc("k", cost(1))
+	if calls != 0 {
+		t.Error("cost called for a blocked key")
+	}
+}
+
+func TestLimitN(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 5, WithClock(clock))
+	defer rl.Close()
+
+	got := decisions(clock, func() bool { return rl.LimitN("k", 3) }, 0, time.Second, time.Second)
+	if want := []bool{false, false, true}; !slices.Equal(got, want) {
+		t.Errorf("LimitN(3) with a burst of 5 limited = %v, want %v", got, want)
+	}
+	if !rl.LimitN("k", 6) {
+		t.Error("LimitN above the burst allowed")
+	}
+	if rl.LimitN("k", -5) {
+		t.Error("LimitN with a negative n limited")
+	}
+	if got := rl.Tokens("k"); got != 1 {
+		t.Errorf("Tokens after LimitN with a negative n = %v, want 1", got)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	rl := New(time.Minute, 10, 1)
+	defer rl.Close()
+
+	if r := rl.Reserve("k"); !r.OK() || r.Delay() != 0 {
+		t.Fatalf("first reservation OK = %v with delay %v, want an immediate one", r.OK(), r.Delay())
+	}
+	r := rl.Reserve("k")
+	if d := r.Delay(); d <= 50*time.Millisecond || d > 100*time.Millisecond {
+		t.Errorf("reservation past the burst has delay %v, want about 100ms", d)
+	}
+	r.Cancel()
+	if r := rl.ReserveN("k", 2); r.OK() {
+		t.Error("reservation above the burst is OK")
+	}
+}
+
+func TestCount(t *testing.T) {
+	rl := New(time.Minute, 1, 1)
+	defer rl.Close()
+
+	for i := range 100 {
+		rl.Limit(strconv.Itoa(i))
+	}
+	for i := range 10 {
+		rl.RemoveEntry(strconv.Itoa(i))
+	}
+	if got := rl.Count(); got != 90 {
+		t.Errorf("Count = %d, want 90", got)
+	}
+}
+
+func TestSetKeyLimit(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.SetKeyLimit("premium", 100, 100)
+	for i := range 99 {
+		if rl.Limit("premium") {
+			t.Fatalf("overridden key limited after %d requests", i+1)
+		}
+	}
+	if rl.Limit("basic") || !rl.Limit("basic") {
+		t.Error("key at the defaults not limited after its burst of 1")
+	}
+	if got := rl.Remaining("premium", RoundDown); got != 1 {
+		t.Errorf("overridden key has %d tokens left, want 1", got)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	rl := New(time.Minute, 1, 3, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for range 5 {
+		if got := rl.Tokens("k"); got != 3 {
+			t.Fatalf("Tokens = %v, want 3", got)
+		}
+	}
+	if got := rl.Count(); got != 0 {
+		t.Errorf("Tokens created %d entries", got)
+	}
+	rl.Limit("k")
+	if got := rl.Tokens("k"); got != 2 {
+		t.Errorf("Tokens after Limit = %v, want 2", got)
+	}
+
+	// A bucket that never refills counts down its burst instead.
+	rl.SetKeyLimit("fixed", 0, 3)
+	rl.Limit("fixed")
+	if got := rl.Tokens("fixed"); got != 2 {
+		t.Errorf("Tokens with no rate after Limit = %v, want 2", got)
+	}
+	if res := rl.Check("fixed"); res.Remaining != 1 {
+		t.Errorf("Check with no rate left %d tokens, want 1", res.Remaining)
+	}
+	if got := rl.AllowUpTo("fixed", 5); got != 1 {
+		t.Errorf("AllowUpTo with no rate granted %d tokens, want 1", got)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	for _, tt := range []struct {
+		interval, expiry time.Duration
+	}{
+		{time.Second, defaultExpiry},
+		{time.Hour, time.Hour},
+	} {
+		clock := newFakeClock()
+		rl := New(tt.interval, 1, 1, WithClock(clock))
+		rl.Limit("k")
+		clock.Advance(tt.expiry)
+		rl.Cleanup()
+		if rl.Count() != 1 {
+			t.Errorf("interval %v: entry removed before the %v expiry", tt.interval, tt.expiry)
+		}
+		clock.Advance(time.Second)
+		rl.Cleanup()
+		if rl.Count() != 0 {
+			t.Errorf("interval %v: entry kept past the %v expiry", tt.interval, tt.expiry)
+		}
+		rl.Close()
+	}
+}
+
+func TestReset(t *testing.T) {
+	rl := New(time.Minute, 1, 3, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for range 4 {
+		rl.Limit("k")
+	}
+	rl.Reset("k")
+	for i := range 3 {
+		if rl.Limit("k") {
+			t.Fatalf("request %d after Reset limited", i)
+		}
+	}
+	if !rl.Limit("k") {
+		t.Error("request past the burst after Reset allowed")
+	}
+
+	rl.SetKeyRate("k", 0)
+	rl.Limit("k")
+	rl.Reset("k")
+	if got := rl.Remaining("k", RoundDown); got != 3 {
+		t.Errorf("Reset refilled a bucket with no rate to %d tokens, want 3", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	rl := New(time.Minute, 1, 3, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for _, remaining := range []int{2, 1, 0} {
+		res := rl.Check("k")
+		if !res.Allowed || res.Remaining != remaining || res.Limit != 3 {
+			t.Fatalf("Check = %+v, want allowed with %d of 3 remaining", res, remaining)
+		}
+	}
+	if res := rl.Check("k"); res.Allowed || res.Remaining != 0 || res.RetryAfter != time.Second {
+		t.Errorf("Check past the burst = %+v, want limited with a second to wait", res)
+	}
+}
+
+func TestGlobalLimit(t *testing.T) {
+	rl := New(time.Minute, 1, 10, WithGlobalLimit(1, 3), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for i := range 3 {
+		if rl.Limit("k") {
+			t.Fatalf("request %d within the global burst limited", i)
+		}
+	}
+	if !rl.Limit("k") {
+		t.Fatal("request past the global burst allowed")
+	}
+	if got := rl.Remaining("k", RoundDown); got != 7 {
+		t.Errorf("key has %d tokens left, want 7: the global limit refused one after it was charged", got)
+	}
+	if !rl.Limit("other") {
+		t.Error("another key allowed past the global limit")
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 10, 1, WithClock(clock))
+	defer rl.Close()
+
+	got := decisions(clock, func() bool { return rl.Limit("k") }, 0, 100*time.Millisecond)
+	rl.SetDefaults(1, 1)
+	got = append(got, decisions(clock, func() bool { return rl.Limit("k") }, 100*time.Millisecond, 900*time.Millisecond)...)
+	if want := []bool{false, false, true, false}; !slices.Equal(got, want) {
+		t.Errorf("limited = %v, want %v", got, want)
+	}
+	if rl.Rate() != 1 || rl.Burst() != 1 {
+		t.Errorf("defaults are %v and %d, want 1 and 1", rl.Rate(), rl.Burst())
+	}
+}
+
+func TestLimitDuringClose(t *testing.T) {
+	rl := New(time.Millisecond, 1000, 10, WithExpiry(time.Millisecond))
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			rl.Limit(strconv.Itoa(i % 8))
+			runtime.Gosched()
+		}
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	rl.Close()
+	close(stop)
+	<-done
+	if rl.Limit("k") {
+		t.Error("Limit after Close limited a new key")
+	}
+}
+
+func TestManualCleanup(t *testing.T) {
+	clock := newFakeClock()
+	before := runtime.NumGoroutine()
+	rl := New(0, 1, 1, WithClock(clock))
+	defer rl.Close()
+	if got := runtime.NumGoroutine(); got != before {
+		t.Errorf("%d goroutines started with a zero cleanup interval", got-before)
+	}
+
+	rl.Limit("stale")
+	clock.Advance(defaultExpiry + time.Second)
+	rl.Limit("fresh")
+	rl.Cleanup()
+	if _, ok := rl.FirstSeen("stale"); ok {
+		t.Error("Cleanup kept the stale entry")
+	}
+	if _, ok := rl.FirstSeen("fresh"); !ok {
+		t.Error("Cleanup removed the fresh entry")
+	}
+}
+
+func TestLimitHierarchical(t *testing.T) {
+	rl := New(time.Minute, 1, 3, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.SetKeyLimit("limited", 1, 0)
+	if !rl.LimitHierarchical("limited", "user") {
+		t.Fatal("child with no burst allowed")
+	}
+	for i := range 3 {
+		if rl.LimitHierarchical("a", "user") {
+			t.Fatalf("request %d within the parent's burst limited", i)
+		}
+	}
+	if !rl.LimitHierarchical("b", "user") {
+		t.Error("child with a full bucket allowed past its exhausted parent")
+	}
+	if got := rl.Remaining("b", RoundDown); got != 3 {
+		t.Errorf("child of an exhausted parent has %d tokens left, want 3", got)
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	clock := newFakeClock()
+	var evicted []string
+	rl := New(0, 1, 1, WithOnEvict(func(k string) { evicted = append(evicted, k) }), WithClock(clock))
+	defer rl.Close()
+
+	rl.Limit("stale")
+	clock.Advance(defaultExpiry)
+	rl.Limit("fresh")
+	clock.Advance(time.Second)
+	rl.Cleanup()
+	if want := []string{"stale"}; !slices.Equal(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+}
+
+func TestLimitAt(t *testing.T) {
+	rl := New(time.Minute, 2, 1)
+	defer rl.Close()
+
+	at := start
+	for i := range 5 {
+		if rl.LimitAt("k", at) {
+			t.Fatalf("request %d spaced by the rate limited", i)
+		}
+		at = at.Add(500 * time.Millisecond)
+	}
+	if rl.LimitAt("k", at) || !rl.LimitAt("k", at.Add(100*time.Millisecond)) {
+		t.Error("clustered requests not limited after the first")
+	}
+}
+
+func TestRemoveEntry(t *testing.T) {
+	rl := New(time.Minute, 1, 1)
+	defer rl.Close()
+
+	rl.Limit("present")
+	if !rl.RemoveEntry("present") {
+		t.Error("RemoveEntry of a tracked key reported false")
+	}
+	if rl.RemoveEntry("absent") {
+		t.Error("RemoveEntry of an unknown key reported true")
+	}
+}
+
+func TestRemoveEntries(t *testing.T) {
+	rl := New(time.Minute, 1, 1)
+	defer rl.Close()
+
+	for i := range 10 {
+		rl.Limit(strconv.Itoa(i))
+	}
+	if got := rl.RemoveEntries([]string{"0", "1", "2", "missing"}); got != 3 {
+		t.Errorf("RemoveEntries removed %d keys, want 3", got)
+	}
+	if got := rl.Count(); got != 7 {
+		t.Errorf("Count after RemoveEntries = %d, want 7", got)
+	}
+	rl.ClearAll()
+	if got := rl.Count(); got != 0 {
+		t.Errorf("Count after ClearAll = %d, want 0", got)
+	}
+	if rl.Limit("3") {
+		t.Error("key limited after ClearAll")
+	}
+}
+
+func TestEmptyStart(t *testing.T) {
+	for _, tt := range []struct {
+		opts []Option
+		want bool
+	}{
+		{nil, false},
+		{[]Option{WithEmptyStart()}, true},
+	} {
+		clock := newFakeClock()
+		rl := New(time.Minute, 1, 1, append(tt.opts, WithClock(clock))...)
+		if got := rl.Limit("k"); got != tt.want {
+			t.Errorf("%d options: first request limited = %v, want %v", len(tt.opts), got, tt.want)
+		}
+		clock.Advance(time.Second)
+		if rl.Limit("k") {
+			t.Errorf("%d options: request after a refill limited", len(tt.opts))
+		}
+		rl.Close()
+	}
+}
+
+func TestLimitCtx(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if !rl.LimitCtx(ctx, "k") {
+		t.Error("cancelled context not limited")
+	}
+	if got := rl.Tokens("k"); got != 1 {
+		t.Errorf("cancelled context took %v tokens", 1-got)
+	}
+	if rl.LimitCtx(context.Background(), "k") {
+		t.Error("live context limited")
+	}
+}
+
+func TestRateAndBurst(t *testing.T) {
+	rl := New(time.Minute, 2.5, 7)
+	defer rl.Close()
+	if rl.Rate() != 2.5 || rl.Burst() != 7 {
+		t.Errorf("Rate and Burst = %v and %d, want 2.5 and 7", rl.Rate(), rl.Burst())
+	}
+}
+
+// sleepClock is a fakeClock that sends the duration of every After call
+// on waits.
+type sleepClock struct {
+	*fakeClock
+	waits chan time.Duration
+}
+
+func (c sleepClock) After(d time.Duration) <-chan time.Time {
+	ch := c.fakeClock.After(d)
+	c.waits <- d
+	return ch
+}
+
+func TestJitter(t *testing.T) {
+	clock := sleepClock{newFakeClock(), make(chan time.Duration)}
+	rl := New(time.Minute, 1, 1, WithJitter(0.1), WithSeed(1), WithClock(clock))
+
+	seen := map[time.Duration]bool{}
+	for range 10 {
+		d := <-clock.waits
+		if d < 54*time.Second || d > 66*time.Second {
+			t.Errorf("waited %v between cleanup passes, want within 10%% of a minute", d)
+		}
+		seen[d] = true
+		clock.Advance(d)
+	}
+	if len(seen) < 2 {
+		t.Error("every wait between cleanup passes was the same")
+	}
+
+	// Let the goroutine's next call to After return, so Close can stop it.
+	closed := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-clock.waits:
+			case <-closed:
+				return
+			}
+		}
+	}()
+	rl.Close()
+	close(closed)
+}
+
+func TestSetKeyBurst(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 2, WithClock(clock))
+	defer rl.Close()
+
+	rl.LimitN("k", 2)
+	rl.SetKeyBurst("k", 5)
+	clock.Advance(10 * time.Second)
+	for i := range 5 {
+		if rl.Limit("k") {
+			t.Fatalf("request %d within the raised burst limited", i)
+		}
+	}
+	if !rl.Limit("k") {
+		t.Error("request past the raised burst allowed")
+	}
+	if !rl.LimitN("other", 3) {
+		t.Error("other key got the raised burst")
+	}
+}
+
+func TestLimitMany(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.Limit("exhausted")
+	got := rl.LimitMany([]string{"fresh", "exhausted", "twice", "twice"})
+	want := map[string]bool{"fresh": false, "exhausted": true, "twice": true}
+	if !maps.Equal(got, want) {
+		t.Errorf("LimitMany = %v, want %v", got, want)
+	}
+}
+
+func TestSeenOnAllow(t *testing.T) {
+	for _, tt := range []struct {
+		opts    []Option
+		evicted bool
+	}{
+		{nil, false},
+		{[]Option{WithSeenOnAllow()}, true},
+	} {
+		clock := newFakeClock()
+		rl := New(0, 0, 1, append(tt.opts, WithClock(clock))...)
+		rl.Limit("k")
+		for range 4 {
+			clock.Advance(time.Minute)
+			if !rl.Limit("k") {
+				t.Fatal("key with no refill allowed")
+			}
+		}
+		rl.Cleanup()
+		if _, tracked := rl.FirstSeen("k"); tracked == tt.evicted {
+			t.Errorf("%d options: continuously denied key evicted = %v, want %v", len(tt.opts), !tracked, tt.evicted)
+		}
+		rl.Close()
+	}
+}
+
+func TestRegisterKey(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	if err := rl.RegisterKey("k", 10, 3); err != nil {
+		t.Fatal(err)
+	}
+	if rl.Count() != 1 {
+		t.Error("RegisterKey didn't create an entry")
+	}
+	for i := range 3 {
+		if rl.Limit("k") {
+			t.Fatalf("request %d within the registered burst limited", i)
+		}
+	}
+	if err := rl.RegisterKey("bad", -1, 1); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("RegisterKey with a negative rate returned %v", err)
+	}
+}
+
+func TestReserveThenRemove(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(0, EveryN(1, 10*time.Minute), 1, WithClock(clock))
+	defer rl.Close()
+
+	rl.Reserve("k")
+	r := rl.Reserve("k")
+	if d := r.DelayFrom(clock.Now()); !r.OK() || d != 10*time.Minute {
+		t.Fatalf("reservation past the burst is OK = %v with delay %v, want 10m", r.OK(), d)
+	}
+	clock.Advance(defaultExpiry + time.Second)
+	rl.Cleanup()
+	if rl.Count() != 1 {
+		t.Fatal("Cleanup removed an entry with a pending reservation")
+	}
+
+	rl.RemoveEntry("k")
+	r.CancelAt(clock.Now())
+	if rl.Limit("k") {
+		t.Error("entry created after RemoveEntry doesn't start with a full burst")
+	}
+}
+
+func TestClockStepBack(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(0, 1, 1, WithClock(clock))
+	defer rl.Close()
+
+	rl.Limit("k")
+	clock.Advance(-time.Hour)
+	rl.Cleanup()
+	if rl.Count() != 1 {
+		t.Fatal("clock going backwards evicted an active entry")
+	}
+	clock.Advance(time.Hour + defaultExpiry)
+	rl.Cleanup()
+	if rl.Count() != 1 {
+		t.Error("entry evicted before it had been idle for the expiry")
+	}
+}
+
+func TestTryLimit(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.Limit("k")
+	sh := rl.shardFor("k")
+	sh.mu.Lock()
+	if _, checked := rl.TryLimit("k"); checked {
+		t.Error("TryLimit checked a key whose shard is locked")
+	}
+	sh.mu.Unlock()
+	if limited, checked := rl.TryLimit("k"); !checked || !limited {
+		t.Errorf("TryLimit = %v, %v, want limited and checked", limited, checked)
+	}
+}
+
+func TestLimitSoft(t *testing.T) {
+	rl := New(time.Minute, 1, 5, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	var got []Decision
+	for range 6 {
+		got = append(got, rl.LimitSoft("k", 0.2))
+	}
+	want := []Decision{Allowed, Allowed, Allowed, AllowedNearLimit, AllowedNearLimit, Denied}
+	if !slices.Equal(got, want) {
+		t.Errorf("LimitSoft = %v, want %v", got, want)
+	}
+}
+
+func TestAllowUpTo(t *testing.T) {
+	rl := New(time.Minute, 1, 5, WithStats(), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.LimitN("k", 2)
+	if got := rl.AllowUpTo("k", 10); got != 3 {
+		t.Errorf("AllowUpTo(10) with 3 tokens granted %d, want 3", got)
+	}
+	if got := rl.AllowUpTo("k", 10); got != 0 {
+		t.Errorf("AllowUpTo(10) with no tokens granted %d, want 0", got)
+	}
+	if got := rl.AllowUpTo("other", 2); got != 2 {
+		t.Errorf("AllowUpTo(2) with 5 tokens granted %d, want 2", got)
+	}
+	for _, n := range []int{0, -3} {
+		if got := rl.AllowUpTo("empty", n); got != 0 {
+			t.Errorf("AllowUpTo(%d) granted %d", n, got)
+		}
+	}
+	if allowed, limited, ok := rl.KeyStats("empty"); ok {
+		t.Errorf("AllowUpTo for no tokens decided the request: %d allowed, %d limited", allowed, limited)
+	}
+}
+
+func TestKeySlots(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithKeySlots(8))
+	defer rl.Close()
+
+	for i := range 1000 {
+		rl.Limit(strconv.Itoa(i))
+	}
+	if got := rl.Count(); got > 8 {
+		t.Errorf("Count = %d with 8 slots", got)
+	}
+	if _, ok := rl.FirstSeen("999"); !ok {
+		t.Error("key not tracked under its slot")
+	}
+}
+
+func TestTimeToAvailable(t *testing.T) {
+	rl := New(time.Minute, 4, 2, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	if got := rl.TimeToAvailable("k"); got != 0 {
+		t.Errorf("TimeToAvailable for a full bucket = %v, want 0", got)
+	}
+	rl.LimitN("k", 2)
+	if got := rl.TimeToAvailable("k"); got != 250*time.Millisecond {
+		t.Errorf("TimeToAvailable for an exhausted bucket = %v, want 250ms", got)
+	}
+	rl.SetKeyLimit("never", 0, 1)
+	rl.Limit("never")
+	if got := rl.TimeToAvailable("never"); got != rate.InfDuration {
+		t.Errorf("TimeToAvailable for an exhausted bucket that never refills = %v", got)
+	}
+}
+
+// recordHandler is a slog.Handler that keeps the records it handles.
+type recordHandler struct {
+	records *[]slog.Record
+}
+
+func (h recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h recordHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h recordHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h recordHandler) Handle(_ context.Context, r slog.Record) error {
+	*h.records = append(*h.records, r)
+	return nil
+}
+
+func TestLoggerEviction(t *testing.T) {
+	var records []slog.Record
+	clock := newFakeClock()
+	rl := New(0, 1, 1, WithLogger(slog.New(recordHandler{&records})), WithClock(clock))
+	defer rl.Close()
+
+	rl.Limit("a")
+	rl.Limit("b")
+	clock.Advance(defaultExpiry + time.Second)
+	rl.Cleanup()
+	if len(records) != 1 {
+		t.Fatalf("logged %d records, want 1", len(records))
+	}
+	r := records[0]
+	attrs := map[string]string{}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+	if r.Level != slog.LevelInfo || r.Message != "ratelimiter: evicted stale entries" || attrs["count"] != "2" {
+		t.Errorf("logged %v %q %v, want an info record of 2 evictions", r.Level, r.Message, attrs)
+	}
+}
+
+func TestEmptyKey(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opt  Option
+		want []bool
+	}{
+		{"WithEmptyKeyLimit", WithEmptyKeyLimit(1, 2), []bool{false, false, true}},
+		{"WithRejectEmptyKeys", WithRejectEmptyKeys(), []bool{true, true, true}},
+	} {
+		rl := New(time.Minute, 1, 5, tt.opt, WithClock(newFakeClock()))
+		got := []bool{rl.Limit(""), rl.Limit(""), rl.Limit("")}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: empty key limited = %v, want %v", tt.name, got, tt.want)
+		}
+		if rl.Limit("k") {
+			t.Errorf("%s: other key limited", tt.name)
+		}
+		// Removing the entry starts it over with the same limit.
+		for name, remove := range map[string]func(){
+			"RemoveEntry":   func() { rl.RemoveEntry("") },
+			"RemoveEntries": func() { rl.RemoveEntries([]string{""}) },
+		} {
+			remove()
+			got := []bool{rl.Limit(""), rl.Limit(""), rl.Limit("")}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s: empty key limited after %s = %v, want %v", tt.name, name, got, tt.want)
+			}
+		}
+		rl.Close()
+	}
+}
+
+func TestSeed(t *testing.T) {
+	limiters := make([]*RateLimiter, 3)
+	for i, seed := range []uint64{1, 1, 2} {
+		limiters[i] = New(0, 1, 1, WithSeed(seed), WithJitter(0.5), WithKeySlots(1000))
+		defer limiters[i].Close()
+	}
+	sequence := func(rl *RateLimiter) []string {
+		var seq []string
+		for i := range 10 {
+			seq = append(seq, rl.jittered(time.Minute).String(), rl.keyOf(strconv.Itoa(i)))
+		}
+		return seq
+	}
+	a, b, c := sequence(limiters[0]), sequence(limiters[1]), sequence(limiters[2])
+	if !slices.Equal(a, b) {
+		t.Errorf("limiters with the same seed differ:\n%v\n%v", a, b)
+	}
+	if slices.Equal(a, c) {
+		t.Error("limiters with different seeds agree")
+	}
+}
+
+func TestLimitWithPenalty(t *testing.T) {
+	rl := New(time.Minute, 1, 2, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.LimitN("k", 2)
+	var delays []time.Duration
+	for range 3 {
+		if !rl.LimitWithPenalty("k", 1) {
+			t.Fatal("request for an empty bucket allowed")
+		}
+		delays = append(delays, rl.TimeToAvailable("k"))
+	}
+	if want := []time.Duration{2 * time.Second, 3 * time.Second, 4 * time.Second}; !slices.Equal(delays, want) {
+		t.Errorf("recovery delays = %v, want %v", delays, want)
+	}
+	rl.Limit("fresh")
+	if rl.LimitWithPenalty("fresh", 5) {
+		t.Error("request with a token limited")
+	}
+	if got := rl.TimeToAvailable("fresh"); got != time.Second {
+		t.Errorf("allowed request penalized: recovery in %v, want 1s", got)
+	}
+}
+
+func TestExpiryTiers(t *testing.T) {
+	clock := newFakeClock()
+	classify := func(k string) string { return k[:strings.IndexByte(k, ':')] }
+	rl := New(time.Hour, 1, 1, WithClock(clock),
+		WithExpiryTiers(classify, map[string]time.Duration{"anon": time.Minute, "tenant": time.Hour}))
+	defer rl.Close()
+
+	for _, k := range []string{"anon:1", "tenant:1", "other:1"} {
+		rl.Limit(k)
+	}
+	clock.Advance(time.Minute + time.Second)
+	rl.Cleanup()
+	if _, ok := rl.FirstSeen("anon:1"); ok {
+		t.Error("Cleanup kept the anon key past its tier's expiry")
+	}
+	for _, k := range []string{"tenant:1", "other:1"} {
+		if _, ok := rl.FirstSeen(k); !ok {
+			t.Errorf("Cleanup removed %s before its expiry", k)
+		}
+	}
+}
+
+func TestNewKeyLimit(t *testing.T) {
+	for name, l := range map[string]interface {
+		Limiter
+		Count() int
+	}{
+		"RateLimiter":     New(time.Minute, 1, 5, WithNewKeyLimit(1, 10), WithClock(newFakeClock())),
+		"StrategyLimiter": NewStrategy(time.Minute, FixedWindow(5, time.Minute), WithNewKeyLimit(1, 10), WithClock(newFakeClock())),
+	} {
+		l.Limit("known")
+		refused := 0
+		for i := range 100 {
+			if l.Limit("flood" + strconv.Itoa(i)) {
+				refused++
+			}
+		}
+		if refused != 91 {
+			t.Errorf("%s: %d of 100 new keys refused, want 91", name, refused)
+		}
+		if got := l.Count(); got != 10 {
+			t.Errorf("%s: %d entries after the flood, want 10", name, got)
+		}
+		if l.Limit("known") {
+			t.Errorf("%s: known key limited during a flood of new keys", name)
+		}
+		l.Close()
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 3, WithClock(clock))
+	defer rl.Close()
+
+	rl.LimitN("k", 3)
+	for _, tt := range []struct {
+		advance           time.Duration
+		down, nearest, up int
+	}{
+		{0, 0, 0, 0},
+		{300 * time.Millisecond, 0, 0, 1},
+		{200 * time.Millisecond, 0, 1, 1},
+		{time.Second, 1, 2, 2},
+		{time.Minute, 3, 3, 3},
+	} {
+		clock.Advance(tt.advance)
+		tokens := rl.Tokens("k")
+		for policy, want := range map[RoundPolicy]int{RoundDown: tt.down, RoundNearest: tt.nearest, RoundUp: tt.up, 9: tt.down} {
+			if got := rl.Remaining("k", policy); got != want {
+				t.Errorf("Remaining(%d) with %v tokens = %d, want %d", policy, tokens, got, want)
+			}
+		}
+	}
+}
+
+func TestEveryN(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, EveryN(5, time.Minute), 5, WithClock(clock))
+	defer rl.Close()
+
+	for i := range 5 {
+		if rl.Limit("k") {
+			t.Fatalf("request %d of 5 per minute limited", i)
+		}
+	}
+	if !rl.Limit("k") {
+		t.Error("6th request of 5 per minute allowed")
+	}
+	clock.Advance(12 * time.Second)
+	if rl.Limit("k") {
+		t.Error("request limited although a token refilled after 12s")
+	}
+	if !rl.Limit("k") {
+		t.Error("request allowed before the next token refilled")
+	}
+	for _, tt := range []struct {
+		n    int
+		per  time.Duration
+		want rate.Limit
+	}{{0, time.Minute, 0}, {5, 0, rate.Inf}} {
+		if got := EveryN(tt.n, tt.per); got != tt.want {
+			t.Errorf("EveryN(%d, %v) = %v, want %v", tt.n, tt.per, got, tt.want)
+		}
+	}
+}
+
+func TestFirstSeen(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 1, WithClock(clock))
+	defer rl.Close()
+
+	if _, ok := rl.FirstSeen("k"); ok {
+		t.Error("FirstSeen reported an unknown key as tracked")
+	}
+	rl.Limit("k")
+	clock.Advance(time.Minute)
+	rl.Limit("k")
+	first, ok := rl.FirstSeen("k")
+	if !ok || !first.Equal(start) {
+		t.Errorf("FirstSeen = %v, %v, want %v", first, ok, start)
+	}
+	rl.Range(func(_ string, lastSeen time.Time, _ float64) bool {
+		if want := start.Add(time.Minute); !lastSeen.Equal(want) {
+			t.Errorf("last seen = %v, want %v", lastSeen, want)
+		}
+		return true
+	})
+}
+
+func TestGetRetryAdvice(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 1, WithRetryAdvice(5*time.Second), WithClock(clock))
+	defer rl.Close()
+
+	rl.Limit("k")
+	var advice []time.Duration
+	for range 4 {
+		rl.Limit("k")
+		advice = append(advice, rl.GetRetryAdvice("k"))
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}; !slices.Equal(advice, want) {
+		t.Errorf("advice after consecutive denials = %v, want %v", advice, want)
+	}
+	clock.Advance(time.Second)
+	if got := rl.GetRetryAdvice("k"); got != 0 {
+		t.Errorf("advice with a token available = %v, want 0", got)
+	}
+	rl.Limit("k")
+	rl.Limit("k")
+	if got := rl.GetRetryAdvice("k"); got != time.Second {
+		t.Errorf("advice after a success and one denial = %v, want 1s", got)
+	}
+}
+
+func TestLimitAll(t *testing.T) {
+	rl := New(time.Minute, 1, 2, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.LimitN("b", 2)
+	if !rl.LimitAll([]string{"a", "b", "c"}) {
+		t.Fatal("LimitAll allowed a set with an exhausted key")
+	}
+	for _, k := range []string{"a", "c"} {
+		if got := rl.Tokens(k); got != 2 {
+			t.Errorf("limited LimitAll left %s with %v tokens, want 2", k, got)
+		}
+	}
+	if rl.LimitAll([]string{"a", "c"}) {
+		t.Fatal("LimitAll limited a set whose keys all have tokens")
+	}
+	if rl.Tokens("a") != 1 || rl.Tokens("c") != 1 {
+		t.Error("allowed LimitAll didn't take a token from each key")
+	}
+	if !rl.LimitAll([]string{"a", "a"}) {
+		t.Error("LimitAll allowed a key listed twice with one token")
+	}
+}
+
+func TestSeenGranularity(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 1, WithExpiry(time.Minute), WithSeenGranularity(time.Second), WithClock(clock))
+	defer rl.Close()
+
+	clock.Advance(1500 * time.Millisecond)
+	rl.Limit("k")
+	rl.Range(func(_ string, lastSeen time.Time, _ float64) bool {
+		if want := start.Add(time.Second); !lastSeen.Equal(want) {
+			t.Errorf("last seen = %v, want it truncated to %v", lastSeen, want)
+		}
+		return true
+	})
+
+	// The entry may go up to the granularity before its true expiry.
+	clock.Advance(59400 * time.Millisecond)
+	rl.Cleanup()
+	if rl.Count() != 1 {
+		t.Fatal("entry removed more than the granularity before its expiry")
+	}
+	clock.Advance(200 * time.Millisecond)
+	rl.Cleanup()
+	if rl.Count() != 0 {
+		t.Error("entry kept a minute after its truncated last seen time")
+	}
+}
+
+func TestSeenCoalescing(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1e6, 1e6, WithSeenCoalescing(time.Second), WithClock(clock))
+	defer rl.Close()
+
+	lastSeen := func() time.Time {
+		var seen time.Time
+		rl.Range(func(_ string, t time.Time, _ float64) bool {
+			seen = t
+			return true
+		})
+		return seen
+	}
+	for _, tt := range []struct {
+		advance time.Duration
+		want    time.Duration
+	}{
+		{0, 0},
+		{500 * time.Millisecond, 0},
+		{499 * time.Millisecond, 0},
+		{time.Millisecond, time.Second},
+		{900 * time.Millisecond, time.Second},
+		{2 * time.Second, 3900 * time.Millisecond},
+	} {
+		clock.Advance(tt.advance)
+		rl.Limit("k")
+		if got, want := lastSeen(), start.Add(tt.want); !got.Equal(want) {
+			t.Errorf("last seen at %v = %v, want %v", clock.Now().Sub(start), got.Sub(start), want.Sub(start))
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package redis provides a ratelimiter.Limiter whose token buckets live in
+// Redis, so that several instances of a service share one limit per key
+// instead of each granting the full rate.
+package redis
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strconv"
+
+	goredis "github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+
+	"github.com/simplifyd-systems/ratelimiter"
+)
+
+// defaultPrefix is prepended to every key stored in Redis when no
+// WithPrefix option is given.
+const defaultPrefix = "ratelimiter:"
+
+// tokenBucket atomically refills and charges the bucket stored in the hash
+// at KEYS[1]. ARGV holds the rate in tokens per second, the burst and the
+// number of tokens requested. Time is read from the Redis server so that
+// instances with skewed clocks agree. It returns 1 if the tokens were
+// granted and 0 otherwise.
+var tokenBucket = goredis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate)
+	ts = now
+end
+
+local allowed = 0
+if n <= tokens then
+	tokens = tokens - n
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', ts)
+if rate > 0 then
+	redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+end
+return allowed
+`)
+
+// Limiter is a token bucket limiter per key stored in Redis.
+type Limiter struct {
+	client         goredis.UniversalClient
+	prefix         string
+	ratePerSec     rate.Limit
+	burstPerPeriod int
+	failMode       ratelimiter.FailMode
+	logger         *slog.Logger
+}
+
+var _ ratelimiter.Limiter = (*Limiter)(nil)
+
+// Option configures optional behaviour of a Limiter created with New.
+type Option func(*Limiter)
+
+// WithPrefix sets the prefix prepended to keys stored in Redis, so that
+// several limiters can share one database. The default is "ratelimiter:".
+func WithPrefix(prefix string) Option {
+	return func(l *Limiter) {
+		l.prefix = prefix
+	}
+}
+
+// WithFailMode sets what Limit decides when Redis can't be reached:
+// ratelimiter.FailOpen, the default, allows the request and
+// ratelimiter.FailClosed limits it.
+func WithFailMode(m ratelimiter.FailMode) Option {
+	return func(l *Limiter) {
+		l.failMode = m
+	}
+}
+
+// WithLogger makes Limit log the Redis errors it hides behind the fail
+// mode through logger, at warn level. A nil logger, the default, logs
+// nothing.
+func WithLogger(logger *slog.Logger) Option {
+	return func(l *Limiter) {
+		l.logger = logger
+	}
+}
+
+// New returns a Limiter that keeps its buckets in Redis through client.
+// Every instance built with the same rate, burst and prefix against the
+// same Redis shares one bucket per key. A negative or NaN rate, which the
+// script can't use, is treated as 0, as the in-memory limiters treat it.
+func New(client goredis.UniversalClient, ratePerSec rate.Limit, burstPerPeriod int, opts ...Option) *Limiter {
+	if math.IsNaN(float64(ratePerSec)) || ratePerSec < 0 {
+		ratePerSec = 0
+	}
+	l := &Limiter{
+		client:         client,
+		prefix:         defaultPrefix,
+		ratePerSec:     ratePerSec,
+		burstPerPeriod: burstPerPeriod,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// AllowN reports whether n tokens can be taken from k's bucket now, taking
+// them if so. It returns an error if Redis can't be reached.
+func (l *Limiter) AllowN(ctx context.Context, k string, n int) (bool, error) {
+	if l.ratePerSec == rate.Inf {
+		return true, nil
+	}
+
+	args := []interface{}{
+		strconv.FormatFloat(float64(l.ratePerSec), 'g', -1, 64),
+		l.burstPerPeriod,
+		n,
+	}
+	res, err := tokenBucket.Run(ctx, l.client, []string{l.prefix + k}, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// Limit returns true if we should limit a request for k. If Redis can't
+// be reached the decision is left to the fail mode, which by default
+// allows the request so that an unavailable Redis doesn't take the
+// service down with it; use AllowN to handle errors yourself.
+func (l *Limiter) Limit(k string) bool {
+	allowed, err := l.AllowN(context.Background(), k, 1)
+	if err != nil {
+		if l.logger != nil {
+			l.logger.Warn("ratelimiter: redis unavailable", "key", k, "mode", l.failMode.String(), "err", err)
+		}
+		return l.failMode.Limited()
+	}
+	return !allowed
+}
+
+// RemoveEntry deletes k's bucket from Redis, giving it a full burst again.
+// It reports whether a bucket was deleted, which is false for an unknown
+// key or if Redis can't be reached.
+func (l *Limiter) RemoveEntry(k string) bool {
+	n, err := l.client.Del(context.Background(), l.prefix+k).Result()
+	return err == nil && n > 0
+}
+
+// Close is a no-op: the Limiter holds no resources of its own, and the
+// client belongs to the caller, who remains responsible for closing it.
+func (l *Limiter) Close() {}
+
+// Healthy pings Redis, returning the error if it can't be reached before
+// ctx is done.
+func (l *Limiter) Healthy(ctx context.Context) error {
+	return l.client.Ping(ctx).Err()
+}
@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+
+	"github.com/simplifyd-systems/ratelimiter"
+)
+
+// newRedis starts a miniredis server with its clock stopped at a fixed
+// time, and returns it with a client connected to it.
+func newRedis(t *testing.T) (*miniredis.Miniredis, goredis.UniversalClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestSharedBurstAcrossInstances(t *testing.T) {
+	mr, _ := newRedis(t)
+	// Two instances of a service, each with its own connection.
+	a := New(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}), 1, 4)
+	b := New(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}), 1, 4)
+
+	for i, l := range []*Limiter{a, b, a, b} {
+		if l.Limit("k") {
+			t.Fatalf("request %d limited within the shared burst", i)
+		}
+	}
+	if !a.Limit("k") || !b.Limit("k") {
+		t.Fatal("requests past the shared burst allowed")
+	}
+	if a.Limit("other") {
+		t.Error("another key shares the exhausted bucket")
+	}
+
+	mr.SetTime(time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC))
+	if b.Limit("k") {
+		t.Error("token refilled after a second not granted")
+	}
+	if !a.Limit("k") {
+		t.Fatal("more than one token refilled after a second")
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	_, client := newRedis(t)
+	l := New(client, 1, 5)
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		ok, err := l.AllowN(ctx, "k", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("AllowN(3) call %d = %v, want %v", i, ok, want)
+		}
+	}
+	if ok, _ := l.AllowN(ctx, "k", 2); !ok {
+		t.Error("AllowN(2) refused with 2 tokens left")
+	}
+}
+
+func TestRemoveEntry(t *testing.T) {
+	_, client := newRedis(t)
+	l := New(client, 1, 1)
+
+	if l.RemoveEntry("k") {
+		t.Error("RemoveEntry reported an unknown key")
+	}
+	l.Limit("k")
+	if !l.Limit("k") {
+		t.Fatal("second request allowed with a burst of 1")
+	}
+	if !l.RemoveEntry("k") {
+		t.Error("RemoveEntry didn't report a known key")
+	}
+	if l.Limit("k") {
+		t.Error("key limited after RemoveEntry")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	mr, client := newRedis(t)
+	a := New(client, 1, 1, WithPrefix("a:"))
+	b := New(client, 1, 1, WithPrefix("b:"))
+
+	a.Limit("k")
+	if b.Limit("k") {
+		t.Error("limiters with different prefixes share a bucket")
+	}
+	if !mr.Exists("a:k") || !mr.Exists("b:k") {
+		t.Errorf("keys stored as %v, want a:k and b:k", mr.Keys())
+	}
+}
+
+func TestInvalidRate(t *testing.T) {
+	_, client := newRedis(t)
+	ctx := context.Background()
+
+	for _, r := range []float64{math.NaN(), -1} {
+		l := New(client, rate.Limit(r), 2, WithPrefix("bad:"), WithFailMode(ratelimiter.FailClosed))
+		for i, want := range []bool{true, true, false} {
+			ok, err := l.AllowN(ctx, "k", 1)
+			if err != nil {
+				t.Fatalf("rate %v: AllowN failed: %v", r, err)
+			}
+			if ok != want {
+				t.Errorf("rate %v: request %d allowed = %v, want %v", r, i, ok, want)
+			}
+		}
+		l.RemoveEntry("k")
+	}
+}
+
+func TestFailMode(t *testing.T) {
+	mr, client := newRedis(t)
+	mr.SetError("ERR unavailable")
+
+	var logs bytes.Buffer
+	open := New(client, 1, 1, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	closed := New(client, 1, 1, WithFailMode(ratelimiter.FailClosed))
+
+	if open.Limit("k") {
+		t.Error("FailOpen limited a request Redis couldn't check")
+	}
+	if !closed.Limit("k") {
+		t.Error("FailClosed allowed a request Redis couldn't check")
+	}
+	if !bytes.Contains(logs.Bytes(), []byte("redis unavailable")) {
+		t.Errorf("error not logged, got %q", logs.String())
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	mr, client := newRedis(t)
+	l := New(client, 1, 1)
+	ctx := context.Background()
+
+	if err := l.Healthy(ctx); err != nil {
+		t.Fatalf("Healthy with Redis up = %v", err)
+	}
+	mr.Close()
+	if err := l.Healthy(ctx); err == nil {
+		t.Error("Healthy with Redis down = nil")
+	}
+}
@@ -0,0 +1,239 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Schedule gives a key different limits at different times of day, such
+// as a higher limit during business hours, for SetKeySchedule.
+type Schedule struct {
+	// Location is the time zone the windows are read in. Nil means UTC.
+	Location *time.Location
+
+	// Windows are the times of day with their own limit. When windows
+	// overlap, the first listed wins.
+	Windows []ScheduleWindow
+
+	// Default is the limit outside every window.
+	Default KeyConfig
+}
+
+// ScheduleWindow is the part of each day, from Start to End as wall clock
+// offsets since midnight, during which a Schedule applies Rate and Burst.
+// A window whose End is before its Start wraps past midnight, so 22:00 to
+// 06:00 covers the night.
+type ScheduleWindow struct {
+	Start, End time.Duration
+	Rate       rate.Limit
+	Burst      int
+}
+
+// schedule is a Schedule in use, with the limit it selected and the span
+// of time until the next window boundary for which that limit holds.
+type schedule struct {
+	Schedule
+	bounds      []time.Duration // The window boundaries, sorted, starting with midnight.
+	limit       keyLimit
+	from, until time.Time
+}
+
+// SetKeySchedule makes k's limit follow s: whenever the window in effect
+// changes, k's bucket is given the new window's rate and burst, keeping
+// its tokens up to the new burst, as SetKeyLimit would. The change is made
+// on the first call for k after a boundary, so an idle key's Tokens may
+// still show the previous window's limit. Like an override, the schedule
+// survives cleanup of idle entries and is dropped by RemoveEntry; it is
+// also replaced by a later SetKeyLimit or RegisterKey. It returns an error
+// wrapping ErrInvalidConfig, and changes nothing, if a window starts or
+// ends outside the day, starts and ends together, or has an invalid rate
+// or burst.
+func (rl *KeyedRateLimiter[K]) SetKeySchedule(k K, s Schedule) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	if s.Location == nil {
+		s.Location = time.UTC
+	}
+	s.Windows = slices.Clone(s.Windows)
+	sc := &schedule{Schedule: s, bounds: []time.Duration{0}}
+	for _, w := range s.Windows {
+		sc.bounds = append(sc.bounds, w.Start, w.End)
+	}
+	slices.Sort(sc.bounds)
+	sc.bounds = slices.Compact(sc.bounds)
+
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.schedules == nil {
+		sh.schedules = make(map[K]*schedule)
+	}
+	if _, ok := sh.schedules[k]; !ok {
+		rl.scheduledKeys.Add(1)
+	}
+	sh.schedules[k] = sc
+	sc.follow(now)
+	rl.applyScheduleLocked(sh, k, sc, now)
+	return nil
+}
+
+// RemoveKeySchedule stops k's limit following the schedule set with
+// SetKeySchedule, returning it to the defaults, or for the zero key to its
+// WithEmptyKeyLimit limit if there is one.
+func (rl *KeyedRateLimiter[K]) RemoveKeySchedule(k K) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, ok := sh.schedules[k]; !ok {
+		return
+	}
+	rl.unscheduleLocked(sh, k)
+	rl.dropOverrideLocked(sh, k)
+	if v, exists := sh.entries[k]; exists && v.limiter != nil {
+		now := rl.clock.Now()
+		lim := rl.limitLocked(sh, k, false)
+		v.limiter.SetLimitAt(now, lim.rate)
+		v.limiter.SetBurstAt(now, lim.burst)
+	}
+}
+
+// unscheduleLocked drops k's schedule, if any, leaving its override as
+// it is. The caller must hold sh.mu.
+func (rl *KeyedRateLimiter[K]) unscheduleLocked(sh *shard[K], k K) {
+	if _, ok := sh.schedules[k]; ok {
+		delete(sh.schedules, k)
+		rl.scheduledKeys.Add(-1)
+	}
+}
+
+// scheduleDueLocked reports whether k has a schedule whose window has
+// changed since it was last applied. The caller must hold sh.mu, for
+// reading at least.
+func (rl *KeyedRateLimiter[K]) scheduleDueLocked(sh *shard[K], k K, now time.Time) bool {
+	if rl.scheduledKeys.Load() == 0 {
+		return false
+	}
+	sc, ok := sh.schedules[k]
+	return ok && sc.stale(now)
+}
+
+// followScheduleLocked applies the window in effect at now to k, if it
+// has a schedule that is due. The caller must hold sh.mu.
+func (rl *KeyedRateLimiter[K]) followScheduleLocked(sh *shard[K], k K, now time.Time) {
+	if !rl.scheduleDueLocked(sh, k, now) {
+		return
+	}
+	sc := sh.schedules[k]
+	if sc.follow(now) {
+		rl.applyScheduleLocked(sh, k, sc, now)
+	}
+}
+
+// applyScheduleLocked makes the limit sc selected k's override, and sets
+// it on k's bucket if there is one. The caller must hold sh.mu.
+func (rl *KeyedRateLimiter[K]) applyScheduleLocked(sh *shard[K], k K, sc *schedule, now time.Time) {
+	if sh.overrides == nil {
+		sh.overrides = make(map[K]keyLimit)
+	}
+	sh.overrides[k] = sc.limit
+	if v, exists := sh.entries[k]; exists && v.limiter != nil {
+		lim := rl.boosting(sc.limit)
+		v.limiter.SetLimitAt(now, lim.rate)
+		v.limiter.SetBurstAt(now, lim.burst)
+		v.probation = false
+	}
+}
+
+// stale reports whether now is outside the span the selected limit holds
+// for.
+func (sc *schedule) stale(now time.Time) bool {
+	return now.Before(sc.from) || !now.Before(sc.until)
+}
+
+// follow selects the limit in effect at now, reporting whether it differs
+// from the one selected before.
+func (sc *schedule) follow(now time.Time) bool {
+	lim, from, until := sc.pick(now)
+	changed := lim != sc.limit
+	sc.limit, sc.from, sc.until = lim, from, until
+	return changed
+}
+
+// at returns the limit in effect at now.
+func (sc *schedule) at(now time.Time) keyLimit {
+	lim, _, _ := sc.pick(now)
+	return lim
+}
+
+// pick returns the limit in effect at now and the span of time around now
+// for which it holds.
+func (sc *schedule) pick(now time.Time) (lim keyLimit, from, until time.Time) {
+	t := now.In(sc.Location)
+	year, month, day := t.Date()
+	hour, minute, sec := t.Clock()
+	offset := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(t.Nanosecond())
+
+	// The limit holds from the last boundary at or before offset until
+	// the next one, or midnight. Building the times from the date keeps
+	// them on the wall clock across daylight saving changes.
+	i, found := slices.BinarySearch(sc.bounds, offset)
+	if !found {
+		i--
+	}
+	next := 24 * time.Hour
+	if i+1 < len(sc.bounds) {
+		next = sc.bounds[i+1]
+	}
+	from = time.Date(year, month, day, 0, 0, 0, int(sc.bounds[i]), sc.Location)
+	until = time.Date(year, month, day, 0, 0, 0, int(next), sc.Location)
+
+	lim = keyLimit{sanitizeRate(sc.Default.Rate), sc.Default.Burst}
+	for _, w := range sc.Windows {
+		if w.covers(offset) {
+			lim = keyLimit{sanitizeRate(w.Rate), w.Burst}
+			break
+		}
+	}
+	return lim, from, until
+}
+
+// covers reports whether the window includes offset since midnight.
+func (w ScheduleWindow) covers(offset time.Duration) bool {
+	if w.Start < w.End {
+		return w.Start <= offset && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// validate checks the schedule's windows and limits, returning every
+// problem found, joined.
+func (s Schedule) validate() error {
+	var errs []error
+	if err := validate(0, s.Default.Rate, s.Default.Burst); err != nil {
+		errs = append(errs, fmt.Errorf("default: %w", err))
+	}
+	for i, w := range s.Windows {
+		switch {
+		case w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End > 24*time.Hour:
+			errs = append(errs, fmt.Errorf("window %d: %w: %v to %v is outside the day", i, ErrInvalidConfig, w.Start, w.End))
+		case w.Start == w.End:
+			errs = append(errs, fmt.Errorf("window %d: %w: starts and ends at %v", i, ErrInvalidConfig, w.Start))
+		default:
+			if err := validate(0, w.Rate, w.Burst); err != nil {
+				errs = append(errs, fmt.Errorf("window %d: %w", i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
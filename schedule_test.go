@@ -0,0 +1,82 @@
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetKeySchedule(t *testing.T) {
+	clock := newFakeClock() // Midnight UTC.
+	rl := New(time.Hour, 1, 1, WithClock(clock))
+	defer rl.Close()
+
+	err := rl.SetKeySchedule("k", Schedule{
+		Windows: []ScheduleWindow{{Start: 9 * time.Hour, End: 17 * time.Hour, Rate: 10, Burst: 10}},
+		Default: KeyConfig{Rate: 1, Burst: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, burst := rl.level("k", clock.Now()); burst != 2 {
+		t.Errorf("burst at midnight = %d, want the default's 2", burst)
+	}
+	clock.Advance(9 * time.Hour)
+	rl.Limit("k")
+	if _, _, burst := rl.level("k", clock.Now()); burst != 10 {
+		t.Errorf("burst at 09:00 = %d, want the window's 10", burst)
+	}
+	clock.Advance(8 * time.Hour)
+	rl.Limit("k")
+	if _, _, burst := rl.level("k", clock.Now()); burst != 2 {
+		t.Errorf("burst at 17:00 = %d, want the default's 2", burst)
+	}
+
+	rl.RemoveKeySchedule("k")
+	if _, _, burst := rl.level("k", clock.Now()); burst != 1 {
+		t.Errorf("burst after RemoveKeySchedule = %d, want the defaults' 1", burst)
+	}
+}
+
+func TestScheduleWrapsMidnight(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(23 * time.Hour)
+	rl := New(time.Hour, 1, 1, WithClock(clock))
+	defer rl.Close()
+
+	err := rl.SetKeySchedule("k", Schedule{
+		Windows: []ScheduleWindow{{Start: 22 * time.Hour, End: 6 * time.Hour, Rate: 1, Burst: 3}},
+		Default: KeyConfig{Rate: 1, Burst: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		advance time.Duration
+		want    int
+	}{{0, 3}, {2 * time.Hour, 3}, {5 * time.Hour, 1}} {
+		clock.Advance(tt.advance)
+		rl.Limit("k")
+		if _, _, burst := rl.level("k", clock.Now()); burst != tt.want {
+			t.Errorf("burst at %v = %d, want %d", clock.Now().Format("15:04"), burst, tt.want)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	day := Schedule{Windows: []ScheduleWindow{{Start: 0, End: 24 * time.Hour, Rate: 1, Burst: 1}}}
+	if err := day.validate(); err != nil {
+		t.Errorf("all day window rejected: %v", err)
+	}
+	for _, s := range []Schedule{
+		{Windows: []ScheduleWindow{{Start: time.Hour, End: time.Hour, Rate: 1, Burst: 1}}},
+		{Windows: []ScheduleWindow{{Start: -time.Hour, End: time.Hour, Rate: 1, Burst: 1}}},
+		{Windows: []ScheduleWindow{{Start: 0, End: 25 * time.Hour, Rate: 1, Burst: 1}}},
+		{Windows: []ScheduleWindow{{Start: 0, End: time.Hour, Rate: 1, Burst: -1}}},
+		{Default: KeyConfig{Rate: -1}},
+	} {
+		if err := s.validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("validate(%+v) = %v, want ErrInvalidConfig", s, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Snapshot is a copy of a limiter's per-key state that can be marshalled
+// as JSON and later handed to Restore, for example to keep throttling
+// state across a deploy.
+type Snapshot[K comparable] struct {
+	Entries []SnapshotEntry[K] `json:"entries"`
+}
+
+// SnapshotEntry is the state of one key in a Snapshot.
+type SnapshotEntry[K comparable] struct {
+	Key       K         `json:"key"`
+	Tokens    float64   `json:"tokens"`
+	FirstSeen time.Time `json:"first_seen"` // When the key's entry was created, zero in snapshots from before it was kept.
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Snapshot returns the available tokens and first and last seen times of
+// every tracked key.
+func (rl *KeyedRateLimiter[K]) Snapshot() Snapshot[K] {
+	now := rl.clock.Now()
+	var snap Snapshot[K]
+	for _, sh := range rl.shards {
+		sh.mu.RLock()
+		for k, v := range sh.entries {
+			snap.Entries = append(snap.Entries, SnapshotEntry[K]{
+				Key:       k,
+				Tokens:    tokensAt(v.limiter, now),
+				FirstSeen: rl.firstSeen(v),
+				LastSeen:  rl.lastSeen(v),
+			})
+		}
+		sh.mu.RUnlock()
+	}
+	return snap
+}
+
+// Restore recreates the entries in snap, replacing any existing entries
+// for the same keys, with their buckets primed to the saved token levels.
+// Restored entries keep their saved first seen time, and with it their
+// progress through WithProbation, or, if the snapshot has none, that of
+// the entry they replace. Entries that have gone unseen for longer than
+// the expiry are dropped.
+// The rate and burst of restored entries come from this limiter's
+// defaults and overrides, and saved levels above the burst are capped.
+func (rl *KeyedRateLimiter[K]) Restore(snap Snapshot[K]) {
+	now := rl.clock.Now()
+	for _, se := range snap.Entries {
+		rl.restoreEntry(se.Key, se.Tokens, se.FirstSeen, se.LastSeen, now, nil)
+	}
+}
+
+// Merge folds other's per-key state into rl, for example to carry the
+// throttling of an instance that is shutting down over to one that stays.
+// Keys tracked only by other are added as Restore would add them, and
+// keys tracked by both keep whichever token level is lower, so the
+// stricter throttle wins, along with the earlier of the two first seen
+// times and the later of the two last seen times. Keys tracked only by rl are left alone. Rates, bursts and
+// overrides are never taken from other: merged entries keep rl's limits,
+// with levels above the burst capped. other is only read, and keeps its
+// state.
+func (rl *KeyedRateLimiter[K]) Merge(other *KeyedRateLimiter[K]) {
+	now := rl.clock.Now()
+	for _, se := range other.Snapshot().Entries {
+		// As with Restore, the keys are used as they are: with
+		// WithKeySlots they are already slots.
+		if !rl.mergeEntry(se.Key, se.Tokens, se.FirstSeen, se.LastSeen, now) {
+			rl.restoreEntry(se.Key, se.Tokens, se.FirstSeen, se.LastSeen, now, nil)
+		}
+	}
+}
+
+// mergeEntry lowers k's token level at now to tokens, if it's higher,
+// moves its first seen time back to firstSeen, if that's earlier and not
+// zero, and moves its last seen time up to lastSeen, if that's later. It
+// reports false, changing nothing, if k isn't tracked.
+func (rl *KeyedRateLimiter[K]) mergeEntry(k K, tokens float64, firstSeen, lastSeen, now time.Time) bool {
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	v, exists := sh.entries[k]
+	if !exists {
+		return false
+	}
+	if tokens < tokensAt(v.limiter, now) {
+		limiter := rate.NewLimiter(v.limiter.Limit(), v.limiter.Burst())
+		primeTokens(limiter, now, tokens)
+		v.limiter = limiter
+	}
+	if !firstSeen.IsZero() && rl.since(firstSeen) < v.created {
+		v.created = rl.since(firstSeen)
+	}
+	if lastSeen.After(rl.lastSeen(v)) {
+		v.seen(rl.since(lastSeen))
+	}
+	return true
+}
+
+// restoreEntry replaces k's entry with one holding tokens at now, first
+// seen at firstSeen and last seen at lastSeen, unless lastSeen is more
+// than the expiry ago. A zero firstSeen keeps the replaced entry's first
+// seen time, or now if there was none. The entry gets the rate and burst
+// in lim, or its defaults, override or probation limit if lim is nil.
+func (rl *KeyedRateLimiter[K]) restoreEntry(k K, tokens float64, firstSeen, lastSeen, now time.Time, lim *keyLimit) {
+	if now.Sub(lastSeen) > rl.expiryOf(k) {
+		return
+	}
+
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	old, existed := sh.entries[k]
+	sh.remove(k)
+	v := rl.entryLocked(sh, k, now)
+	switch {
+	case !firstSeen.IsZero() && firstSeen.Before(now):
+		v.created = rl.since(firstSeen)
+	case existed:
+		v.created = old.created
+	}
+	if existed {
+		v.probation = old.probation
+	}
+	if rl.graduating(v, now) {
+		v.probation = false
+	}
+	if lim == nil {
+		l := rl.limitLocked(sh, k, v.probation)
+		lim = &l
+	}
+	// Start from a full bucket even with WithEmptyStart.
+	v.limiter = rate.NewLimiter(lim.rate, lim.burst)
+	primeTokens(v.limiter, now, tokens)
+	v.seen(rl.since(lastSeen))
+}
+
+// primeTokens drains a full limiter so that it holds tokens at now.
+// Negative levels, left by reservations, are treated as empty.
+func primeTokens(limiter *rate.Limiter, now time.Time, tokens float64) {
+	b := limiter.Burst()
+	r := limiter.Limit()
+	if tokens >= float64(b) || r == rate.Inf {
+		return
+	}
+	if tokens < 0 {
+		tokens = 0
+	}
+
+	if r <= 0 {
+		// The bucket never refills, so only whole tokens can be kept.
+		limiter.AllowN(now, b-int(tokens))
+		return
+	}
+	// Empty the bucket at the point in the past from which it refills to
+	// exactly tokens by now.
+	d := time.Duration(tokens / float64(r) * float64(time.Second))
+	limiter.AllowN(now.Add(-d), b)
+}
+
+// Range calls f for every tracked key with its last seen time and
+// available tokens, stopping early if f returns false. The keys are
+// collected before f is first called, so f may safely call back into the
+// limiter, for example calling Meta for the key's metadata, but it may
+// see keys that have since been removed.
+func (rl *KeyedRateLimiter[K]) Range(f func(k K, lastSeen time.Time, tokens float64) bool) {
+	for _, se := range rl.Snapshot().Entries {
+		if !f(se.Key, se.LastSeen, se.Tokens) {
+			return
+		}
+	}
+}
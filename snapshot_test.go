@@ -0,0 +1,167 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 5, WithClock(clock))
+	defer rl.Close()
+	rl.LimitN("a", 3)
+	rl.LimitN("b", 5)
+
+	data, err := json.Marshal(rl.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap Snapshot[string]
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := New(time.Minute, 1, 5, WithClock(clock))
+	defer restored.Close()
+	restored.Restore(snap)
+	for k, want := range map[string]int{"a": 2, "b": 0} {
+		if got := restored.Remaining(k, RoundDown); got != want {
+			t.Errorf("Remaining(%q) after Restore = %d, want %d", k, got, want)
+		}
+	}
+
+	clock.Advance(defaultExpiry + time.Second)
+	stale := New(time.Minute, 1, 5, WithClock(clock))
+	defer stale.Close()
+	stale.Restore(snap)
+	if got := stale.Count(); got != 0 {
+		t.Errorf("Restore kept %d entries unseen for longer than the expiry", got)
+	}
+}
+
+func TestRestoreFirstSeen(t *testing.T) {
+	clock := newFakeClock()
+	opts := []Option{WithProbation(time.Hour, 1, 1), WithClock(clock)}
+	rl := New(time.Minute, 1, 5, opts...)
+	defer rl.Close()
+
+	rl.Limit("old")
+	clock.Advance(2 * time.Hour)
+	rl.Limit("old")
+	rl.Limit("young")
+	snap := rl.Snapshot()
+
+	clock.Advance(time.Minute)
+	restored := New(time.Minute, 1, 5, opts...)
+	defer restored.Close()
+	restored.Restore(snap)
+	for k, want := range map[string]time.Time{"old": start, "young": start.Add(2 * time.Hour)} {
+		if got, _ := restored.FirstSeen(k); !got.Equal(want) {
+			t.Errorf("FirstSeen(%s) after Restore = %v, want %v", k, got, want)
+		}
+	}
+	// Only the young key is still on probation, with its burst of 1.
+	clock.Advance(time.Minute)
+	for k, want := range map[string]int{"old": 5, "young": 1} {
+		if got := restored.Remaining(k, RoundDown); got != want {
+			t.Errorf("Remaining(%s) after Restore = %d, want %d", k, got, want)
+		}
+	}
+
+	// A snapshot without first seen times keeps those of the entries it
+	// replaces.
+	for i := range snap.Entries {
+		snap.Entries[i].FirstSeen = time.Time{}
+	}
+	restored.Restore(snap)
+	if got, _ := restored.FirstSeen("old"); !got.Equal(start) {
+		t.Errorf("FirstSeen after restoring in place = %v, want %v", got, start)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 5, WithClock(clock))
+	other := New(time.Minute, 1, 5, WithClock(clock))
+	defer rl.Close()
+	defer other.Close()
+
+	rl.LimitN("both", 1)
+	rl.LimitN("mine", 1)
+	other.LimitN("both", 4)
+	other.LimitN("theirs", 2)
+	rl.Merge(other)
+
+	for k, want := range map[string]int{"both": 1, "mine": 4, "theirs": 3} {
+		if got := rl.Remaining(k, RoundDown); got != want {
+			t.Errorf("Remaining(%q) after Merge = %d, want %d", k, got, want)
+		}
+	}
+	if got := other.Remaining("both", RoundDown); got != 1 {
+		t.Errorf("Merge changed other: Remaining = %d, want 1", got)
+	}
+}
+
+func TestMergeKeySlots(t *testing.T) {
+	clock := newFakeClock()
+	rl := New(time.Minute, 1, 5, WithKeySlots(64), WithSeed(1), WithClock(clock))
+	other := New(time.Minute, 1, 5, WithKeySlots(64), WithSeed(1), WithClock(clock))
+	defer rl.Close()
+	defer other.Close()
+
+	other.LimitN("k", 4)
+	rl.Merge(other)
+	if got := rl.Remaining("k", RoundDown); got != 1 {
+		t.Errorf("Remaining after merging a slotted limiter = %d, want 1", got)
+	}
+	if got := rl.Count(); got != 1 {
+		t.Errorf("Count = %d, want 1", got)
+	}
+}
+
+func TestRange(t *testing.T) {
+	rl := New(time.Minute, 1, 5, WithClock(newFakeClock()))
+	defer rl.Close()
+	want := map[string]float64{"a": 4, "b": 3, "c": 0}
+	for k, tokens := range want {
+		rl.LimitN(k, 5-int(tokens))
+	}
+
+	got := map[string]float64{}
+	rl.Range(func(k string, lastSeen time.Time, tokens float64) bool {
+		if !lastSeen.Equal(start) {
+			t.Errorf("%s last seen at %v, want %v", k, lastSeen, start)
+		}
+		got[k] = tokens
+		return true
+	})
+	if !maps.Equal(got, want) {
+		t.Errorf("Range visited %v, want %v", got, want)
+	}
+
+	visits := 0
+	rl.Range(func(string, time.Time, float64) bool {
+		visits++
+		return false
+	})
+	if visits != 1 {
+		t.Errorf("Range visited %d keys after f returned false", visits)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var flushed []Snapshot[string]
+	rl := New(time.Minute, 1, 5, WithClock(newFakeClock()),
+		WithFlush(func(s Snapshot[string]) { flushed = append(flushed, s) }))
+	rl.LimitN("k", 2)
+	rl.Close()
+	rl.Close()
+	if len(flushed) != 1 {
+		t.Fatalf("flush called %d times, want once", len(flushed))
+	}
+	if e := flushed[0].Entries; len(e) != 1 || e[0].Key != "k" || e[0].Tokens != 3 {
+		t.Errorf("flushed entries = %+v, want k with 3 tokens", e)
+	}
+}
@@ -0,0 +1,176 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"cmp"
+	"container/heap"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// counters counts a limiter's decisions when WithStats is used.
+type counters struct {
+	allowed atomic.Uint64
+	limited atomic.Uint64
+}
+
+// count adds one decision.
+func (c *counters) count(limited bool) {
+	if limited {
+		c.limited.Add(1)
+	} else {
+		c.allowed.Add(1)
+	}
+}
+
+// Stats summarises a limiter's state for logging and debugging.
+type Stats struct {
+	Keys    int        // Keys currently tracked.
+	Rate    rate.Limit // Rate of keys without an override.
+	Burst   int        // Burst of keys without an override.
+	Counted bool       // Whether Allowed and Limited are counted, see WithStats.
+	Allowed uint64     // Requests allowed since the limiter was created.
+	Limited uint64     // Requests limited since the limiter was created.
+}
+
+// Stats returns a summary of the limiter's state. It is cheap enough to
+// call periodically, taking each shard's lock only briefly.
+func (rl *KeyedRateLimiter[K]) Stats() Stats {
+	st := Stats{
+		Keys:  rl.Count(),
+		Rate:  rl.Rate(),
+		Burst: rl.Burst(),
+	}
+	if rl.stats != nil {
+		st.Counted = true
+		st.Allowed = rl.stats.allowed.Load()
+		st.Limited = rl.stats.limited.Load()
+	}
+	return st
+}
+
+// String implements fmt.Stringer with a one line summary of Stats.
+func (rl *KeyedRateLimiter[K]) String() string {
+	st := rl.Stats()
+	s := fmt.Sprintf("ratelimiter: %d keys, rate %g/s, burst %d", st.Keys, float64(st.Rate), st.Burst)
+	if st.Counted {
+		s += fmt.Sprintf(", %d allowed, %d limited", st.Allowed, st.Limited)
+	}
+	return s
+}
+
+// KeyStats returns how many requests for k have been allowed and limited
+// since its entry was created or last Reset. ok is false if k isn't being
+// tracked or the limiter wasn't created with WithStats.
+func (rl *KeyedRateLimiter[K]) KeyStats(k K) (allowed, limited uint64, ok bool) {
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	v, exists := sh.entries[k]
+	if !exists || v.stats == nil {
+		return 0, 0, false
+	}
+	return v.stats.allowed.Load(), v.stats.limited.Load(), true
+}
+
+// KeyStat is one key's decision counts, as reported by TopN.
+type KeyStat[K comparable] struct {
+	Key     K
+	Allowed uint64
+	Limited uint64
+}
+
+// Total returns the number of requests decided for the key.
+func (s KeyStat[K]) Total() uint64 {
+	return s.Allowed + s.Limited
+}
+
+// TopN returns the n tracked keys with the most requests, allowed and
+// limited together, busiest first, for spotting hot keys. Counts are
+// those KeyStats reports, so they cover the time since each entry was
+// created, and keys that go idle drop out once cleanup removes them. Keys
+// are ranked with a heap of n entries rather than by sorting them all, so
+// small n stays cheap on large limiters. It returns nil if the limiter
+// wasn't created with WithStats or n is zero or less.
+func (rl *KeyedRateLimiter[K]) TopN(n int) []KeyStat[K] {
+	if rl.stats == nil || n <= 0 {
+		return nil
+	}
+	var top topKeys[K]
+	for _, sh := range rl.shards {
+		sh.mu.RLock()
+		for k, v := range sh.entries {
+			if v.stats == nil {
+				continue
+			}
+			s := KeyStat[K]{k, v.stats.allowed.Load(), v.stats.limited.Load()}
+			if len(top) < n {
+				heap.Push(&top, s)
+			} else if s.Total() > top[0].Total() {
+				top[0] = s
+				heap.Fix(&top, 0)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	slices.SortFunc(top, func(a, b KeyStat[K]) int {
+		return cmp.Compare(b.Total(), a.Total())
+	})
+	return top
+}
+
+// topKeys is a min-heap of KeyStats by total, the least busy on top so
+// it's the one replaced by a busier key.
+type topKeys[K comparable] []KeyStat[K]
+
+func (h topKeys[K]) Len() int           { return len(h) }
+func (h topKeys[K]) Less(i, j int) bool { return h[i].Total() < h[j].Total() }
+func (h topKeys[K]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topKeys[K]) Push(x any)        { *h = append(*h, x.(KeyStat[K])) }
+
+func (h *topKeys[K]) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// WriteOpenMetrics writes rl's Stats to w in the OpenMetrics text format,
+// for scrapers that read a plain text endpoint, with the same metrics as
+// the collector in the prometheus subpackage: gauges for the number of
+// tracked keys and the default rate and burst, and, with WithStats, a
+// counter of allowed and limited requests.
+func (rl *KeyedRateLimiter[K]) WriteOpenMetrics(w io.Writer) error {
+	st := rl.Stats()
+	var b bytes.Buffer
+	gauge := func(name, help string, v float64) {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n# HELP %s %s\n%s %s\n", name, name, help, name, formatFloat(v))
+	}
+	gauge("ratelimiter_keys", "Number of keys currently tracked.", float64(st.Keys))
+	gauge("ratelimiter_rate", "Tokens per second added to the bucket of keys without an override.", float64(st.Rate))
+	gauge("ratelimiter_burst", "Bucket size of keys without an override.", float64(st.Burst))
+	if st.Counted {
+		b.WriteString("# TYPE ratelimiter_requests counter\n")
+		b.WriteString("# HELP ratelimiter_requests Requests decided, by whether they were allowed or limited.\n")
+		fmt.Fprintf(&b, "ratelimiter_requests_total{decision=\"allowed\"} %d\n", st.Allowed)
+		fmt.Fprintf(&b, "ratelimiter_requests_total{decision=\"limited\"} %d\n", st.Limited)
+	}
+	b.WriteString("# EOF\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// formatFloat formats v as OpenMetrics expects, spelling infinity +Inf.
+func formatFloat(v float64) string {
+	if v == float64(rate.Inf) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,112 @@
+package ratelimiter
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestStats(t *testing.T) {
+	rl := New(time.Minute, 2, 3, WithStats(), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for range 5 {
+		rl.Limit("a")
+	}
+	rl.Limit("b")
+	want := Stats{Keys: 2, Rate: 2, Burst: 3, Counted: true, Allowed: 4, Limited: 2}
+	if got := rl.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	if got, want := rl.String(), "ratelimiter: 2 keys, rate 2/s, burst 3, 4 allowed, 2 limited"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
+
+func TestKeyStats(t *testing.T) {
+	rl := New(time.Minute, 1, 2, WithStats(), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for range 5 {
+		rl.Limit("a")
+	}
+	rl.Limit("b")
+	for k, want := range map[string][2]uint64{"a": {2, 3}, "b": {1, 0}} {
+		allowed, limited, ok := rl.KeyStats(k)
+		if !ok || allowed != want[0] || limited != want[1] {
+			t.Errorf("KeyStats(%s) = %d, %d, %v, want %d, %d, true", k, allowed, limited, ok, want[0], want[1])
+		}
+	}
+	rl.Reset("a")
+	if allowed, limited, _ := rl.KeyStats("a"); allowed != 0 || limited != 0 {
+		t.Errorf("KeyStats after Reset = %d, %d, want 0, 0", allowed, limited)
+	}
+	if _, _, ok := rl.KeyStats("unknown"); ok {
+		t.Error("KeyStats reported an unknown key")
+	}
+}
+
+func TestTopN(t *testing.T) {
+	rl := New(time.Minute, 1, 2, WithStats(), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	for i, k := range []string{"a", "b", "c", "d"} {
+		for range 2*i + 1 {
+			rl.Limit(k)
+		}
+	}
+	want := []KeyStat[string]{{"d", 2, 5}, {"c", 2, 3}, {"b", 2, 1}}
+	if got := rl.TopN(3); !slices.Equal(got, want) {
+		t.Errorf("TopN(3) = %+v, want %+v", got, want)
+	}
+	if got := rl.TopN(10); len(got) != 4 {
+		t.Errorf("TopN(10) returned %d keys, want all 4", len(got))
+	}
+	plain := New(time.Minute, 1, 2)
+	defer plain.Close()
+	plain.Limit("k")
+	if got := plain.TopN(3); got != nil {
+		t.Errorf("TopN without WithStats = %+v, want nil", got)
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	rl := New(time.Minute, rate.Inf, 3, WithStats())
+	defer rl.Close()
+	rl.Limit("a")
+
+	var b strings.Builder
+	if err := rl.WriteOpenMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if lines[len(lines)-1] != "# EOF" {
+		t.Errorf("output doesn't end with # EOF:\n%s", b.String())
+	}
+	samples := map[string]string{}
+	for _, line := range lines[:len(lines)-1] {
+		if strings.HasPrefix(line, "# TYPE ") || strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		if _, err := strconv.ParseFloat(value, 64); !ok || err != nil {
+			t.Errorf("line %q isn't a sample", line)
+		}
+		samples[name] = value
+	}
+	want := map[string]string{
+		"ratelimiter_keys":  "1",
+		"ratelimiter_rate":  "+Inf",
+		"ratelimiter_burst": "3",
+		`ratelimiter_requests_total{decision="allowed"}`: "1",
+		`ratelimiter_requests_total{decision="limited"}`: "0",
+	}
+	if !maps.Equal(samples, want) {
+		t.Errorf("samples = %v, want %v", samples, want)
+	}
+}
@@ -0,0 +1,422 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Strategy is an algorithm other than the default token bucket for
+// deciding whether a key's requests are allowed, used by limiters built
+// with NewStrategy. Strategies are created with functions such as
+// FixedWindow.
+type Strategy interface {
+	newCounter() counter
+}
+
+// counter is the per-key state of a Strategy. Its methods are called with
+// the entry's shard locked, so implementations needn't be safe for
+// concurrent use.
+type counter interface {
+	// allowN reports whether n more requests are allowed at now,
+	// recording them if so.
+	allowN(now time.Time, n int) bool
+}
+
+// weightedCounter is implemented by counters that count requests by a
+// fractional weight rather than a whole number.
+type weightedCounter interface {
+	// allowWeight reports whether a request of weight w is allowed at
+	// now, recording it if so.
+	allowWeight(now time.Time, w float64) bool
+}
+
+// pendingCounter is implemented by counters whose state must outlive the
+// expiry, so that cleanup doesn't reset them early.
+type pendingCounter interface {
+	// pending reports whether the counter holds state at now that
+	// would be lost if its entry were removed.
+	pending(now time.Time) bool
+}
+
+// StrategyLimiter is a keyed limiter that decides using a Strategy rather
+// than a token bucket. It shares the sharding, expiry and cleanup of
+// KeyedRateLimiter, and takes the same options, but only offers the
+// methods that make sense for every strategy.
+type StrategyLimiter[K comparable] struct {
+	rl *KeyedRateLimiter[K]
+}
+
+// NewStrategy returns a string keyed limiter that decides using s. The
+// cleanupInterval and options behave as they do for New.
+func NewStrategy(cleanupInterval time.Duration, s Strategy, opts ...Option) *StrategyLimiter[string] {
+	return NewKeyedStrategy[string](cleanupInterval, s, opts...)
+}
+
+// NewKeyedStrategy is like NewStrategy but returns a limiter keyed by K.
+func NewKeyedStrategy[K comparable](cleanupInterval time.Duration, s Strategy, opts ...Option) *StrategyLimiter[K] {
+	rl := NewKeyed[K](cleanupInterval, 0, 0, opts...)
+	rl.strategy = s
+	return &StrategyLimiter[K]{rl}
+}
+
+// Limit returns true if a request for k should be limited.
+func (sl *StrategyLimiter[K]) Limit(k K) bool {
+	return sl.LimitN(k, 1)
+}
+
+// LimitN is like Limit but counts the request as n requests. A negative n
+// counts as nothing, as zero and negative weights do for LimitWeighted, so
+// it can't hand requests back.
+func (sl *StrategyLimiter[K]) LimitN(k K, n int) bool {
+	return sl.limit(k, n, float64(n))
+}
+
+// LimitWeighted is like Limit but counts the request by weight, such as a
+// risk score, for strategies like DecayingSum that cap the sum of weights
+// rather than the number of requests. Other strategies count it as the
+// weight rounded up to a whole number of requests. Weights of zero or
+// less count as nothing, while NaN and infinite weights are limited.
+func (sl *StrategyLimiter[K]) LimitWeighted(k K, weight float64) bool {
+	n := 0
+	switch {
+	case math.IsNaN(weight) || math.IsInf(weight, 1):
+		// Left for limit to refuse, as no count can hold them.
+	case weight > 0:
+		n = int(min(math.Ceil(weight), math.MaxInt32))
+	default:
+		weight = 0
+	}
+	return sl.limit(k, n, weight)
+}
+
+// limit implements LimitN and LimitWeighted, counting the request as n
+// requests, or by weight for strategies that count weights.
+func (sl *StrategyLimiter[K]) limit(k K, n int, weight float64) bool {
+	if n < 0 || weight < 0 {
+		n, weight = 0, 0
+	}
+	rl := sl.rl
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		return limited
+	}
+	sh := rl.shardFor(k)
+	sh.mu.Lock()
+	if rl.refuseLocked(sh, k, now) {
+		// As with KeyedRateLimiter.Limit, refused keys aren't reported.
+		sh.mu.Unlock()
+		return true
+	}
+	v := rl.entryLocked(sh, k, now)
+	limited, decided := rl.preemptLocked(sh, k, now)
+	if !decided {
+		if wc, ok := v.counter.(weightedCounter); ok {
+			limited = !wc.allowWeight(now, weight)
+		} else {
+			limited = math.IsNaN(weight) || math.IsInf(weight, 1) || !v.counter.allowN(now, n)
+		}
+	}
+	sh.mu.Unlock()
+
+	rl.observe(k, now, limited)
+	if rl.decisions != nil {
+		rl.decisions.add(DecisionRecord[K]{Time: now, Key: k, Allowed: !limited})
+	}
+	return limited
+}
+
+// RemoveEntry forgets the state held for k, reporting whether there was
+// any.
+func (sl *StrategyLimiter[K]) RemoveEntry(k K) bool {
+	return sl.rl.RemoveEntry(k)
+}
+
+// Count returns the number of keys currently tracked.
+func (sl *StrategyLimiter[K]) Count() int {
+	return sl.rl.Count()
+}
+
+// Cleanup removes the entries that haven't been seen for more than the
+// configured expiry, as KeyedRateLimiter.Cleanup does.
+func (sl *StrategyLimiter[K]) Cleanup() {
+	sl.rl.Cleanup()
+}
+
+// Close stops the background cleanup goroutine, as KeyedRateLimiter.Close
+// does.
+func (sl *StrategyLimiter[K]) Close() {
+	sl.rl.Close()
+}
+
+// FixedWindow returns a Strategy that allows up to limit requests per key
+// in each window, with the count resetting at every window boundary.
+// Windows are aligned to multiples of window since the zero time, so a one
+// minute window resets at the start of each calendar minute. Up to twice
+// limit requests can get through around a boundary.
+func FixedWindow(limit int, window time.Duration) Strategy {
+	return fixedWindowStrategy{limit, window}
+}
+
+type fixedWindowStrategy struct {
+	limit  int
+	window time.Duration
+}
+
+func (s fixedWindowStrategy) newCounter() counter {
+	return &fixedWindow{limit: s.limit, window: s.window}
+}
+
+// fixedWindow counts the requests made since start.
+type fixedWindow struct {
+	limit  int
+	window time.Duration
+	start  time.Time
+	count  int
+}
+
+func (w *fixedWindow) allowN(now time.Time, n int) bool {
+	if start := now.Truncate(w.window); !start.Equal(w.start) {
+		w.start = start
+		w.count = 0
+	}
+	if w.count+n > w.limit {
+		return false
+	}
+	w.count += n
+	return true
+}
+
+// SlidingWindowLog returns a Strategy that allows a request only if fewer
+// than limit requests for the key were allowed during the window before
+// it. Unlike FixedWindow it never lets a burst through at window edges,
+// but it remembers the time of every allowed request in the window, so it
+// costs O(limit) memory per key.
+func SlidingWindowLog(limit int, window time.Duration) Strategy {
+	return slidingWindowStrategy{limit, window}
+}
+
+type slidingWindowStrategy struct {
+	limit  int
+	window time.Duration
+}
+
+func (s slidingWindowStrategy) newCounter() counter {
+	return &slidingWindow{limit: s.limit, window: s.window}
+}
+
+// slidingWindow logs the times of the requests allowed within the window,
+// oldest first.
+type slidingWindow struct {
+	limit  int
+	window time.Duration
+	log    []time.Time
+}
+
+func (w *slidingWindow) allowN(now time.Time, n int) bool {
+	// Drop the requests that have fallen out of the window.
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.log) && !w.log[i].After(cutoff) {
+		i++
+	}
+	w.log = w.log[i:]
+
+	if len(w.log)+n > w.limit {
+		return false
+	}
+	for ; n > 0; n-- {
+		w.log = append(w.log, now)
+	}
+	return true
+}
+
+// Tier is one of the token buckets a key is subject to under MultiRate.
+type Tier struct {
+	Rate  rate.Limit
+	Burst int
+}
+
+// MultiRate returns a Strategy that holds a token bucket per tier for each
+// key, such as 10 per second and 100 per minute and 1000 per hour, and
+// allows a request only if every tier has the tokens for it. Tokens are
+// then taken from all the tiers, and from none when any of them limits.
+func MultiRate(tiers ...Tier) Strategy {
+	return multiRateStrategy(tiers)
+}
+
+type multiRateStrategy []Tier
+
+func (s multiRateStrategy) newCounter() counter {
+	m := make(multiRate, len(s))
+	for i, t := range s {
+		m[i] = rate.NewLimiter(sanitizeRate(t.Rate), t.Burst)
+	}
+	return m
+}
+
+// multiRate is a key's token bucket for each tier.
+type multiRate []*rate.Limiter
+
+func (m multiRate) allowN(now time.Time, n int) bool {
+	taken := make([]*rate.Reservation, 0, len(m))
+	for _, limiter := range m {
+		r, ok := takeNow(limiter, now, n)
+		if !ok {
+			for _, r := range taken {
+				r.CancelAt(now)
+			}
+			return false
+		}
+		taken = append(taken, r)
+	}
+	return true
+}
+
+// LeakyBucket returns a Strategy that spaces each key's requests at least
+// interval apart, with no bursts, for downstreams that need evenly paced
+// traffic. A request counted as n requests holds off the next one for n
+// intervals.
+func LeakyBucket(interval time.Duration) Strategy {
+	return leakyBucketStrategy{interval}
+}
+
+type leakyBucketStrategy struct {
+	interval time.Duration
+}
+
+func (s leakyBucketStrategy) newCounter() counter {
+	return &leakyBucket{interval: s.interval}
+}
+
+// leakyBucket holds the earliest time the next request may be allowed.
+type leakyBucket struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func (b *leakyBucket) allowN(now time.Time, n int) bool {
+	if now.Before(b.next) {
+		return false
+	}
+	b.next = now.Add(time.Duration(n) * b.interval)
+	return true
+}
+
+// Period is the calendar period of a CalendarWindow quota.
+type Period int
+
+const (
+	Daily   Period = iota // Resets at midnight.
+	Monthly               // Resets at midnight on the first of the month.
+)
+
+// CalendarWindow returns a Strategy that allows up to limit requests per
+// key in each calendar day or month, with the count resetting at the
+// period's boundary in loc, such as midnight in the customer's time zone,
+// for billing quotas. A nil loc means UTC. Entries are kept by cleanup
+// while their period has requests counted in it, however long the key is
+// idle, so the quota isn't forgotten before it resets.
+func CalendarWindow(limit int, period Period, loc *time.Location) Strategy {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return calendarStrategy{limit, period, loc}
+}
+
+type calendarStrategy struct {
+	limit  int
+	period Period
+	loc    *time.Location
+}
+
+func (s calendarStrategy) newCounter() counter {
+	return &calendarWindow{calendarStrategy: s}
+}
+
+// calendarWindow counts the requests allowed in the period from start to
+// end.
+type calendarWindow struct {
+	calendarStrategy
+	start, end time.Time
+	count      int
+}
+
+func (w *calendarWindow) allowN(now time.Time, n int) bool {
+	if now.Before(w.start) || !now.Before(w.end) {
+		t := now.In(w.loc)
+		if w.period == Monthly {
+			w.start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, w.loc)
+			w.end = w.start.AddDate(0, 1, 0)
+		} else {
+			w.start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, w.loc)
+			w.end = w.start.AddDate(0, 0, 1)
+		}
+		w.count = 0
+	}
+	if w.count+n > w.limit {
+		return false
+	}
+	w.count += n
+	return true
+}
+
+func (w *calendarWindow) pending(now time.Time) bool {
+	return w.count > 0 && now.Before(w.end)
+}
+
+// DecayingSum returns a Strategy that caps the sum of the weights of each
+// key's requests, given with LimitWeighted, at budget, with every weight's
+// contribution decaying exponentially over time, by a factor of e every
+// window, rather than dropping out at a window edge. A request is allowed
+// only if the decayed sum plus its weight stays within budget, and then
+// its weight is added. Weights of zero or less are always allowed and add
+// nothing, while NaN weights are limited. Limit and LimitN count requests
+// as weights of 1 and n. An entry's sum is forgotten when cleanup removes
+// it, so window should be short next to the expiry.
+func DecayingSum(budget float64, window time.Duration) Strategy {
+	return decayingSumStrategy{budget, window}
+}
+
+type decayingSumStrategy struct {
+	budget float64
+	window time.Duration
+}
+
+func (s decayingSumStrategy) newCounter() counter {
+	return &decayingSum{decayingSumStrategy: s}
+}
+
+// decayingSum holds the sum of the weights allowed, decayed up to at.
+type decayingSum struct {
+	decayingSumStrategy
+	sum float64
+	at  time.Time
+}
+
+func (d *decayingSum) allowN(now time.Time, n int) bool {
+	return d.allowWeight(now, float64(n))
+}
+
+func (d *decayingSum) allowWeight(now time.Time, w float64) bool {
+	if w <= 0 {
+		return true
+	}
+	if elapsed := now.Sub(d.at); elapsed > 0 && d.sum > 0 {
+		if d.window > 0 {
+			d.sum *= math.Exp(-elapsed.Seconds() / d.window.Seconds())
+		} else {
+			d.sum = 0
+		}
+	}
+	if now.After(d.at) {
+		d.at = now
+	}
+	if math.IsNaN(w) || d.sum+w > d.budget {
+		return false
+	}
+	d.sum += w
+	return true
+}
@@ -0,0 +1,231 @@
+package ratelimiter
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// decisions calls limit once per step, advancing clock by the step's
+// delay first, and returns whether each call was limited.
+func decisions(clock *fakeClock, limit func() bool, delays ...time.Duration) []bool {
+	got := make([]bool, len(delays))
+	for i, d := range delays {
+		clock.Advance(d)
+		got[i] = limit()
+	}
+	return got
+}
+
+func TestStrategies(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		s      Strategy
+		delays []time.Duration
+		want   []bool
+	}{
+		{
+			name:   "FixedWindow",
+			s:      FixedWindow(2, time.Minute),
+			delays: []time.Duration{0, 0, 0, time.Minute},
+			want:   []bool{false, false, true, false},
+		},
+		{
+			name:   "SlidingWindowLog",
+			s:      SlidingWindowLog(2, time.Minute),
+			delays: []time.Duration{0, 30 * time.Second, 0, 30 * time.Second, 0},
+			want:   []bool{false, false, true, false, true},
+		},
+		{
+			name:   "MultiRate",
+			s:      MultiRate(Tier{Rate: 1, Burst: 2}, Tier{Rate: rate.Every(time.Minute), Burst: 3}),
+			delays: []time.Duration{0, 0, 0, time.Second, time.Second},
+			want:   []bool{false, false, true, false, true},
+		},
+		{
+			name:   "LeakyBucket",
+			s:      LeakyBucket(time.Second),
+			delays: []time.Duration{0, 0, time.Second, 500 * time.Millisecond},
+			want:   []bool{false, true, false, true},
+		},
+		{
+			name:   "CalendarWindow",
+			s:      CalendarWindow(1, Daily, time.UTC),
+			delays: []time.Duration{0, 23 * time.Hour, time.Hour},
+			want:   []bool{false, true, false},
+		},
+		{
+			name:   "DecayingSum",
+			s:      DecayingSum(2, time.Minute),
+			delays: []time.Duration{0, 0, 0, time.Minute},
+			want:   []bool{false, false, true, false},
+		},
+	} {
+		clock := newFakeClock()
+		sl := NewStrategy(time.Hour, tt.s, WithClock(clock))
+		got := decisions(clock, func() bool { return sl.Limit("k") }, tt.delays...)
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: request %d limited = %v, want %v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+		sl.Close()
+	}
+}
+
+func TestLimitWeighted(t *testing.T) {
+	for _, s := range []Strategy{DecayingSum(3, time.Minute), FixedWindow(3, time.Minute)} {
+		sl := NewStrategy(time.Hour, s, WithClock(newFakeClock()))
+		for _, w := range []float64{math.NaN(), math.Inf(1)} {
+			if !sl.LimitWeighted("k", w) {
+				t.Errorf("%T: weight %v allowed", s, w)
+			}
+		}
+		for _, w := range []float64{-5, math.Inf(-1), 0} {
+			if sl.LimitWeighted("k", w) {
+				t.Errorf("%T: weight %v limited", s, w)
+			}
+		}
+		// The weights above counted for nothing, so the whole budget is left.
+		if sl.LimitWeighted("k", 2.5) || !sl.LimitWeighted("k", 1) {
+			t.Errorf("%T: budget spent by weights that count as nothing", s)
+		}
+		sl.Close()
+	}
+}
+
+func TestLimitNNegative(t *testing.T) {
+	for _, s := range []Strategy{
+		FixedWindow(2, time.Minute),
+		SlidingWindowLog(2, time.Minute),
+		MultiRate(Tier{Rate: EveryN(1, time.Hour), Burst: 2}),
+		LeakyBucket(time.Minute),
+		CalendarWindow(2, Daily, nil),
+		DecayingSum(2, time.Minute),
+	} {
+		sl := NewStrategy(time.Hour, s, WithClock(newFakeClock()))
+		for i := 0; !sl.Limit("k"); i++ {
+			if i == 10 {
+				t.Fatalf("%T: 10 requests allowed", s)
+			}
+		}
+		sl.LimitN("k", -10)
+		if !sl.Limit("k") {
+			t.Errorf("%T: a negative LimitN handed requests back", s)
+		}
+		sl.Close()
+	}
+}
+
+func TestFixedWindowBoundary(t *testing.T) {
+	clock := newFakeClock()
+	sl := NewStrategy(time.Hour, FixedWindow(3, time.Minute), WithClock(clock))
+	defer sl.Close()
+
+	clock.Advance(30 * time.Second)
+	got := decisions(clock, func() bool { return sl.Limit("k") }, 0, 0, 0, 0, 29*time.Second, time.Second)
+	if want := []bool{false, false, false, true, true, false}; !slices.Equal(got, want) {
+		t.Errorf("limited = %v, want %v", got, want)
+	}
+}
+
+func TestSlidingWindowStraddlingBoundary(t *testing.T) {
+	for _, tt := range []struct {
+		s    Strategy
+		want []bool
+	}{
+		{FixedWindow(2, time.Minute), []bool{false, false, false}},
+		{SlidingWindowLog(2, time.Minute), []bool{false, false, true}},
+	} {
+		clock := newFakeClock()
+		sl := NewStrategy(time.Hour, tt.s, WithClock(clock))
+		got := decisions(clock, func() bool { return sl.Limit("k") }, 50*time.Second, 0, 20*time.Second)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%T: limited = %v, want %v", tt.s, got, tt.want)
+		}
+		sl.Close()
+	}
+}
+
+func TestMultiRate(t *testing.T) {
+	clock := newFakeClock()
+	sl := NewStrategy(time.Hour, MultiRate(Tier{Rate: 10, Burst: 10}, Tier{Rate: EveryN(20, time.Minute), Burst: 20}), WithClock(clock))
+	defer sl.Close()
+
+	for second := range 2 {
+		for i := range 10 {
+			if sl.Limit("k") {
+				t.Fatalf("request %d in second %d limited", i, second)
+			}
+		}
+		if !sl.Limit("k") {
+			t.Fatalf("request past the per-second tier in second %d allowed", second)
+		}
+		clock.Advance(time.Second)
+	}
+	if !sl.Limit("k") {
+		t.Error("request past the per-minute tier allowed although the per-second tier refilled")
+	}
+}
+
+func TestLeakyBucket(t *testing.T) {
+	clock := newFakeClock()
+	sl := NewStrategy(time.Hour, LeakyBucket(time.Second), WithClock(clock))
+	defer sl.Close()
+
+	got := decisions(clock, func() bool { return sl.Limit("k") }, 0, 0, 999*time.Millisecond, time.Millisecond)
+	if want := []bool{false, true, true, false}; !slices.Equal(got, want) {
+		t.Errorf("limited = %v, want %v", got, want)
+	}
+}
+
+func TestCalendarWindow(t *testing.T) {
+	// Midnight at UTC+5 comes 19 hours after the clock's UTC start.
+	loc := time.FixedZone("UTC+5", 5*60*60)
+	for _, tt := range []struct {
+		name   string
+		s      Strategy
+		delays []time.Duration
+		want   []bool
+	}{
+		{"Daily", CalendarWindow(1, Daily, loc), []time.Duration{0, 0, 18*time.Hour + 59*time.Minute, time.Minute}, []bool{false, true, true, false}},
+		{"Monthly", CalendarWindow(2, Monthly, nil), []time.Duration{0, 0, 30 * 24 * time.Hour, 24 * time.Hour}, []bool{false, false, true, false}},
+	} {
+		clock := newFakeClock()
+		sl := NewStrategy(time.Hour, tt.s, WithClock(clock))
+		got := decisions(clock, func() bool { return sl.Limit("k") }, tt.delays...)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: limited = %v, want %v", tt.name, got, tt.want)
+		}
+		sl.Close()
+	}
+}
+
+func TestDecayingSum(t *testing.T) {
+	clock := newFakeClock()
+	sl := NewStrategy(time.Hour, DecayingSum(10, time.Minute), WithClock(clock))
+	defer sl.Close()
+
+	for i, tt := range []struct {
+		delay   time.Duration
+		weight  float64
+		limited bool
+	}{
+		{0, 4, false},
+		{0, 5.5, false},
+		{0, 0.6, true},
+		{0, 0.5, false},
+		// A window decays the sum of 10 to 10/e, about 3.68.
+		{time.Minute, 6.3, false},
+		{0, 0.1, true},
+		{10 * time.Minute, 9.9, false},
+	} {
+		clock.Advance(tt.delay)
+		if got := sl.LimitWeighted("k", tt.weight); got != tt.limited {
+			t.Errorf("request %d of weight %v limited = %v, want %v", i, tt.weight, got, tt.limited)
+		}
+	}
+}
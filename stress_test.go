@@ -0,0 +1,104 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentUse hammers a limiter with most options enabled from many
+// goroutines at once, mixing decisions with the calls that remove, reset
+// and clean up entries, so that -race catches unguarded state.
+func TestConcurrentUse(t *testing.T) {
+	configs := map[string][]Option{
+		"defaults": nil,
+		"options": {
+			WithExpiry(time.Millisecond),
+			WithGlobalLimit(1e6, 1000),
+			WithMaxKeys(64),
+			WithStats(),
+			WithSeenOnAllow(),
+			WithPenalty(5, time.Second, time.Millisecond),
+			WithProbation(time.Millisecond, 10, 5),
+			WithRetryAdvice(time.Second),
+			WithPriorityHeadroom(0.2),
+			WithDecisionLog(16),
+			WithObserver[string](nopObserver{}),
+			WithWhenAllowed(func(string, float64) {}),
+			WithOnEvict(func(string) {}),
+		},
+		"slots": {WithKeySlots(8), WithNewKeyLimit(1e6, 100), WithFairShare(time.Second)},
+	}
+	for name, opts := range configs {
+		t.Run(name, func(t *testing.T) {
+			rl := New(time.Millisecond, 1000, 10, opts...)
+			defer rl.Close()
+			rl.SetKeyCredit("k0", 5)
+			rl.Notify()
+			stress(rl)
+		})
+	}
+}
+
+// nopObserver is an Observer that ignores every decision.
+type nopObserver struct{}
+
+func (nopObserver) OnAllow(string) {}
+func (nopObserver) OnLimit(string) {}
+
+func stress(rl *RateLimiter) {
+	ops := []func(k string){
+		func(k string) { rl.Limit(k) },
+		func(k string) { rl.LimitN(k, 3) },
+		func(k string) { rl.Check(k) },
+		func(k string) { rl.LimitSoft(k, 0.5) },
+		func(k string) { rl.LimitPriority(k, PriorityNormal) },
+		func(k string) { rl.LimitWithPenalty(k, 2) },
+		func(k string) { rl.LimitMany([]string{k, "k0"}) },
+		func(k string) { rl.LimitAll([]string{k, "k1"}) },
+		func(k string) { rl.LimitHierarchical(k, "k2") },
+		func(k string) { rl.AllowUpTo(k, 4) },
+		func(k string) { rl.TryLimit(k) },
+		func(k string) {
+			if tok, ok := rl.Admit(k); ok {
+				tok.Rollback()
+			}
+		},
+		func(k string) { rl.Refund(k, 2) },
+		func(k string) { rl.Reset(k) },
+		func(k string) { rl.RemoveEntry(k) },
+		func(k string) { rl.RemoveEntries([]string{k, "k3"}) },
+		func(k string) { rl.Cleanup() },
+		func(k string) { rl.SetKeyLimit(k, 500, 5) },
+		func(k string) { rl.DisableKey(k); rl.EnableKey(k) },
+		func(k string) { rl.BlockKey(k, time.Millisecond) },
+		func(k string) { rl.Tokens(k); rl.GetRetryAdvice(k); rl.KeyStats(k) },
+		func(k string) { rl.Snapshot(); rl.TopN(3); rl.Stats() },
+		func(k string) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			rl.Wait(ctx, k)
+			cancel()
+		},
+		func(k string) {
+			if r := rl.Reserve(k); r.OK() {
+				r.Cancel()
+			}
+		},
+	}
+
+	// Every operation runs on its own goroutine over a handful of keys,
+	// so each gets to overlap with all the others.
+	var wg sync.WaitGroup
+	for _, op := range ops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				op("k" + strconv.Itoa(i%4))
+			}
+		}()
+	}
+	wg.Wait()
+}
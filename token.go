@@ -0,0 +1,116 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Token is a request admitted by Admit whose token can still be handed
+// back, for requests that may turn out not to need it.
+type Token struct {
+	mu     sync.Mutex
+	refund func() // Hands the token back to the buckets it was taken from.
+	done   bool
+}
+
+// Admit is like Limit but returns a Token for the admitted request, which
+// the caller must settle with Commit once the request has done its work
+// or Rollback if it failed early, say in validation, so a legitimate retry
+// isn't charged twice. It returns nil and false if the request should be
+// limited.
+func (rl *KeyedRateLimiter[K]) Admit(k K) (*Token, bool) {
+	k = rl.keyOf(k)
+	now := rl.clock.Now()
+	if limited, decided := rl.listed(k, now); decided {
+		if limited {
+			return nil, false
+		}
+		// No token was taken, so there is nothing to hand back.
+		return &Token{refund: func() {}}, true
+	}
+	limiter := rl.admitEntryAt(k, now)
+	if limiter == nil || rl.decide(k, limiter, now, 1) {
+		return nil, false
+	}
+	return &Token{refund: func() { rl.refundAt(k, limiter, rl.clock.Now(), 1) }}, true
+}
+
+// Commit keeps the token. It and Rollback only have an effect the first
+// time either is called.
+func (t *Token) Commit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = true
+}
+
+// Rollback returns the token to the key's bucket, and to the global bucket
+// if there is one, up to their bursts. If the key's entry has been removed
+// since, the token goes back to the removed bucket and has no effect.
+func (t *Token) Rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return
+	}
+	t.done = true
+	t.refund()
+}
+
+// Refund returns n tokens to k's bucket, and to the global bucket if
+// there is one, for requests admitted with Limit that turned out to do no
+// work, say a stream whose client went away early, typically from a
+// deferred call in the handler. Refunds are clamped at the burst, so
+// handing back more tokens than were taken just fills the bucket. It does
+// nothing if n isn't positive or k isn't tracked, since a new entry starts
+// full anyway.
+func (rl *KeyedRateLimiter[K]) Refund(k K, n int) {
+	if n <= 0 {
+		return
+	}
+	k = rl.keyOf(k)
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	var limiter *rate.Limiter
+	if v, exists := sh.entries[k]; exists {
+		limiter = v.limiter
+	}
+	sh.mu.RUnlock()
+	if limiter == nil {
+		return
+	}
+	rl.refundAt(k, limiter, rl.clock.Now(), n)
+}
+
+// refundAt returns n tokens to k's bucket limiter, and to the global
+// bucket if there is one. The caller must not hold k's shard lock.
+func (rl *KeyedRateLimiter[K]) refundAt(k K, limiter *rate.Limiter, now time.Time, n int) {
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	probation := false
+	if v, exists := sh.entries[k]; exists {
+		probation = v.probation
+	}
+	burst := rl.limitLocked(sh, k, probation).burst
+	sh.mu.RUnlock()
+
+	refund(limiter, now, n, burst)
+	if rl.global != nil {
+		refund(rl.global, now, n, rl.globalBurst)
+	}
+}
+
+// refund returns n tokens to limiter at now. rate.Limiter has no way to
+// add tokens, but taking a negative number of them does just that, and
+// any excess over the burst is dropped the next time the bucket is read.
+// Cancelling a reservation isn't an option, since the limiter refuses to
+// once its time to act has passed. At a rate of 0 the limiter takes
+// tokens out of its burst instead, so taking a negative number grows the
+// burst, which is clamped back to burst, the one it is configured with.
+func refund(limiter *rate.Limiter, now time.Time, n, burst int) {
+	limiter.AllowN(now, -n)
+	if limiter.Limit() == 0 && limiter.Burst() > burst {
+		limiter.SetBurstAt(now, burst)
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimiter
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefund(t *testing.T) {
+	rl := New(time.Minute, 1, 3, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.LimitN("k", 3)
+	rl.Refund("k", 2)
+	if got := rl.Tokens("k"); got != 2 {
+		t.Errorf("Tokens after refunding 2 = %v, want 2", got)
+	}
+	rl.Refund("k", 5)
+	if got := rl.Tokens("k"); got != 3 {
+		t.Errorf("Tokens after refunding past the burst = %v, want 3", got)
+	}
+
+	rl.Refund("unknown", 1)
+	if rl.Count() != 1 {
+		t.Errorf("Refund created an entry for an unknown key")
+	}
+}
+
+func TestRefundDuringReset(t *testing.T) {
+	rl := New(time.Minute, 1, 5)
+	defer rl.Close()
+	rl.Limit("k")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 1000 {
+			rl.Refund("k", 1)
+			runtime.Gosched()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 1000 {
+			rl.Reset("k")
+			runtime.Gosched()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestAdmitRollback(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithClock(newFakeClock()))
+	defer rl.Close()
+
+	tok, ok := rl.Admit("k")
+	if !ok {
+		t.Fatal("first request refused")
+	}
+	if _, ok := rl.Admit("k"); ok {
+		t.Fatal("second request admitted with a burst of 1")
+	}
+	tok.Rollback()
+	tok.Rollback()
+	if got := rl.Tokens("k"); got != 1 {
+		t.Errorf("Tokens after Rollback = %v, want 1", got)
+	}
+
+	tok, _ = rl.Admit("k")
+	tok.Commit()
+	tok.Rollback()
+	if got := rl.Tokens("k"); got != 0 {
+		t.Errorf("Tokens after Commit then Rollback = %v, want 0", got)
+	}
+}
+
+func TestRefundAtRateZero(t *testing.T) {
+	rl := New(time.Minute, 0, 2, WithGlobalLimit(0, 2), WithClock(newFakeClock()))
+	defer rl.Close()
+
+	rl.Limit("k")
+	rl.Refund("k", 5)
+	tok, _ := rl.Admit("k")
+	tok.Rollback()
+	for i, want := range []bool{false, false, true} {
+		if got := rl.Limit("k"); got != want {
+			t.Errorf("request %d limited = %v, want %v", i, got, want)
+		}
+	}
+	if got := rl.Burst(); got != 2 {
+		t.Errorf("default burst = %d, want 2", got)
+	}
+}
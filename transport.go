@@ -0,0 +1,73 @@
+package ratelimiter
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that throttles outbound requests, such
+// as calls to a third-party API, by the key KeyFunc derives from each
+// request, before passing them on to Base. By default a request over the
+// limit waits for a token, as Wait does, until the request's context is
+// done; with NoWait it fails straight away with a *RateLimitError instead.
+// Either way the request isn't sent, and RoundTrip returns the error. Both
+// honour the WithGlobalLimit bucket, the allowlist and the blocklist, so
+// a blocked key fails with ErrBlocked when waiting.
+type Transport[K comparable] struct {
+	// Limiter decides which requests may be sent.
+	Limiter *KeyedRateLimiter[K]
+
+	// Base sends the requests. Nil means http.DefaultTransport.
+	Base http.RoundTripper
+
+	// KeyFunc returns the key for a request. Nil limits by RequestHost,
+	// which requires string keys.
+	KeyFunc func(*http.Request) K
+
+	// NoWait makes requests over the limit fail rather than wait.
+	NoWait bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport[K]) RoundTrip(req *http.Request) (*http.Response, error) {
+	k, err := t.key(req)
+	if err == nil {
+		if t.NoWait {
+			err = t.Limiter.LimitOrError(k)
+		} else {
+			err = t.Limiter.Wait(req.Context(), k)
+		}
+	}
+	if err != nil {
+		// A RoundTripper must close the body even when it doesn't send
+		// the request.
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// key returns the key for req.
+func (t *Transport[K]) key(req *http.Request) (K, error) {
+	if t.KeyFunc != nil {
+		return t.KeyFunc(req), nil
+	}
+	if f, ok := any(RequestHost).(func(*http.Request) K); ok {
+		return f(req), nil
+	}
+	var zero K
+	return zero, errors.New("ratelimiter: Transport needs a KeyFunc for non-string keys")
+}
+
+// RequestHost returns the host of the URL r is sent to, including any
+// port, as a key for throttling outbound requests per host.
+func RequestHost(r *http.Request) string {
+	return r.URL.Host
+}
@@ -0,0 +1,66 @@
+package ratelimiter
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	rl := New(time.Minute, 0.001, 1)
+	defer rl.Close()
+	client := &http.Client{Transport: &Transport[string]{Limiter: rl, NoWait: true}}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	body := &closeRecorder{Reader: strings.NewReader("x")}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, body)
+	_, err = client.Do(req)
+	var rle *RateLimitError[string]
+	if !errors.As(err, &rle) {
+		t.Fatalf("request over the limit got %v, want a *RateLimitError", err)
+	}
+	if rle.Key != req.URL.Host {
+		t.Errorf("limited on %q, want the host %q", rle.Key, req.URL.Host)
+	}
+	if !body.closed {
+		t.Error("body of the unsent request not closed")
+	}
+}
+
+func TestTransportWaitBlocked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	rl := New(time.Minute, 1, 1)
+	defer rl.Close()
+	client := &http.Client{Transport: &Transport[string]{
+		Limiter: rl,
+		KeyFunc: func(*http.Request) string { return "api" },
+	}}
+
+	rl.BlockKey("api", 0)
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrBlocked) {
+		t.Errorf("request for a blocked key got %v, want ErrBlocked", err)
+	}
+}
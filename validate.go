@@ -0,0 +1,96 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrInvalidConfig is wrapped by the errors NewValidated returns for
+// settings that would produce a limiter that behaves surprisingly.
+var ErrInvalidConfig = errors.New("ratelimiter: invalid configuration")
+
+// NewValidated is like New but first checks the settings, returning an
+// error wrapping ErrInvalidConfig for a negative cleanup interval, rate or
+// burst, or a NaN rate.
+func NewValidated(cleanupInterval time.Duration, ratePerSec rate.Limit, burstPerPeriod int, opts ...Option) (*RateLimiter, error) {
+	return NewKeyedValidated[string](cleanupInterval, ratePerSec, burstPerPeriod, opts...)
+}
+
+// NewKeyedValidated is like NewValidated but returns a limiter keyed by K.
+func NewKeyedValidated[K comparable](cleanupInterval time.Duration, ratePerSec rate.Limit, burstPerPeriod int, opts ...Option) (*KeyedRateLimiter[K], error) {
+	if err := validate(cleanupInterval, ratePerSec, burstPerPeriod); err != nil {
+		return nil, err
+	}
+	return NewKeyed[K](cleanupInterval, ratePerSec, burstPerPeriod, opts...), nil
+}
+
+// MustNew is like NewValidated but panics if the settings are invalid.
+func MustNew(cleanupInterval time.Duration, ratePerSec rate.Limit, burstPerPeriod int, opts ...Option) *RateLimiter {
+	rl, err := NewValidated(cleanupInterval, ratePerSec, burstPerPeriod, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return rl
+}
+
+// KeyConfig is the rate and burst of one key, or of the default tier, for
+// NewFromConfig.
+type KeyConfig struct {
+	Rate  rate.Limit `json:"rate" yaml:"rate"`
+	Burst int        `json:"burst" yaml:"burst"`
+}
+
+// NewFromConfig is like NewValidated but also registers every key in keys
+// with its own limit, as RegisterKey does, with defaults applying to the
+// keys not listed. It checks all of the settings first and returns every
+// problem found, joined, without creating a limiter if there are any.
+func NewFromConfig(cleanupInterval time.Duration, defaults KeyConfig, keys map[string]KeyConfig, opts ...Option) (*RateLimiter, error) {
+	var errs []error
+	if err := validate(cleanupInterval, defaults.Rate, defaults.Burst); err != nil {
+		errs = append(errs, fmt.Errorf("defaults: %w", err))
+	}
+	for _, k := range slices.Sorted(maps.Keys(keys)) {
+		if err := validate(0, keys[k].Rate, keys[k].Burst); err != nil {
+			errs = append(errs, fmt.Errorf("key %q: %w", k, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	rl := New(cleanupInterval, defaults.Rate, defaults.Burst, opts...)
+	for k, c := range keys {
+		rl.RegisterKey(k, c.Rate, c.Burst)
+	}
+	return rl, nil
+}
+
+// validate checks the settings shared by the constructors.
+func validate(cleanupInterval time.Duration, ratePerSec rate.Limit, burstPerPeriod int) error {
+	switch {
+	case cleanupInterval < 0:
+		return fmt.Errorf("%w: negative cleanup interval %v", ErrInvalidConfig, cleanupInterval)
+	case math.IsNaN(float64(ratePerSec)):
+		return fmt.Errorf("%w: rate is NaN", ErrInvalidConfig)
+	case ratePerSec < 0:
+		return fmt.Errorf("%w: negative rate %v", ErrInvalidConfig, ratePerSec)
+	case burstPerPeriod < 0:
+		return fmt.Errorf("%w: negative burst %d", ErrInvalidConfig, burstPerPeriod)
+	}
+	return nil
+}
+
+// sanitizeRate replaces a negative or NaN rate, which rate.Limiter doesn't
+// handle sensibly, with 0.
+func sanitizeRate(r rate.Limit) rate.Limit {
+	if math.IsNaN(float64(r)) || r < 0 {
+		return 0
+	}
+	return r
+}
@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestNewValidated(t *testing.T) {
+	for _, tt := range []struct {
+		interval time.Duration
+		rate     rate.Limit
+		burst    int
+		want     string
+	}{
+		{-time.Second, 1, 1, "negative cleanup interval"},
+		{time.Second, rate.Limit(math.NaN()), 1, "rate is NaN"},
+		{time.Second, -1, 1, "negative rate"},
+		{time.Second, 1, -1, "negative burst"},
+	} {
+		rl, err := NewValidated(tt.interval, tt.rate, tt.burst)
+		if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewValidated(%v, %v, %d) error = %v, want %q", tt.interval, tt.rate, tt.burst, err, tt.want)
+		}
+		if rl != nil {
+			t.Errorf("NewValidated(%v, %v, %d) returned a limiter", tt.interval, tt.rate, tt.burst)
+		}
+	}
+
+	rl, err := NewValidated(0, rate.Inf, 0)
+	if err != nil {
+		t.Fatalf("NewValidated with valid settings: %v", err)
+	}
+	rl.Close()
+}
+
+func TestInvalidRate(t *testing.T) {
+	for _, r := range []rate.Limit{rate.Limit(math.NaN()), -1} {
+		rl := New(time.Minute, r, 2, WithClock(newFakeClock()))
+		if rl.Rate() != 0 {
+			t.Errorf("rate %v stored as %v, want 0", r, rl.Rate())
+		}
+		if rl.Limit("k") || rl.Limit("k") || !rl.Limit("k") {
+			t.Errorf("rate %v: key not limited to its burst", r)
+		}
+		if got := rl.TimeToAvailable("k"); got != rate.InfDuration {
+			t.Errorf("rate %v: TimeToAvailable = %v, want never", r, got)
+		}
+		rl.SetKeyRate("k", r)
+		rl.SetKeyLimit("other", r, 1)
+		rl.SetDefaults(r, 1)
+		if rl.Rate() != 0 {
+			t.Errorf("rate %v set by SetDefaults as %v, want 0", r, rl.Rate())
+		}
+		rl.Close()
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	rl, err := NewFromConfig(time.Minute, KeyConfig{Rate: 1, Burst: 1}, map[string]KeyConfig{
+		"premium": {Rate: 10, Burst: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	for k, want := range map[string]int{"premium": 3, "other": 1} {
+		if got := rl.Remaining(k, RoundDown); got != want {
+			t.Errorf("%s starts with %d tokens, want %d", k, got, want)
+		}
+	}
+
+	_, err = NewFromConfig(time.Minute, KeyConfig{Rate: -1}, map[string]KeyConfig{
+		"a": {Burst: -1},
+		"b": {Rate: 1},
+	})
+	for _, want := range []string{"defaults: ", `key "a": `} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("NewFromConfig error = %v, want one containing %q", err, want)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), `"b"`) {
+		t.Errorf("NewFromConfig reported the valid key: %v", err)
+	}
+}
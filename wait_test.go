@@ -0,0 +1,125 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	rl := New(time.Minute, 20, 1)
+	defer rl.Close()
+	ctx := context.Background()
+
+	begin := time.Now()
+	for range 3 {
+		if err := rl.Wait(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(begin); elapsed < 90*time.Millisecond {
+		t.Errorf("three waits at 20/s took %v, want about 100ms", elapsed)
+	}
+	if err := rl.WaitN(ctx, "k", 2); err == nil {
+		t.Error("WaitN past the burst succeeded")
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	rl := New(time.Minute, 1, 1)
+	defer rl.Close()
+	rl.Limit("k")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rl.Wait(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait past the deadline = %v, want DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := rl.Wait(ctx, "other"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait with a cancelled context = %v, want Canceled", err)
+	}
+}
+
+func TestWaitGlobalLimit(t *testing.T) {
+	rl := New(time.Minute, 100, 10, WithGlobalLimit(1, 1))
+	defer rl.Close()
+
+	if err := rl.Wait(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := rl.Wait(ctx, "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait with the global bucket empty = %v, want DeadlineExceeded", err)
+	}
+	if !rl.Limit("c") {
+		t.Error("Wait that gave up kept its global token")
+	}
+}
+
+func TestWaitListed(t *testing.T) {
+	rl := New(time.Minute, 0, 0)
+	defer rl.Close()
+	rl.AllowKeys("friend")
+	rl.BlockKey("foe", 0)
+
+	ctx := context.Background()
+	if err := rl.Wait(ctx, "friend"); err != nil {
+		t.Errorf("Wait for an allowlisted key = %v", err)
+	}
+	if err := rl.Wait(ctx, "foe"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Wait for a blocked key = %v, want ErrBlocked", err)
+	}
+	if err := rl.WaitUntilAllowed(ctx, "foe", time.Second); !errors.Is(err, ErrBlocked) {
+		t.Errorf("WaitUntilAllowed for a blocked key = %v, want ErrBlocked", err)
+	}
+}
+
+func TestWaitUntilAllowed(t *testing.T) {
+	rl := New(time.Minute, 10, 1)
+	defer rl.Close()
+	ctx := context.Background()
+
+	if err := rl.WaitUntilAllowed(ctx, "k", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.WaitUntilAllowed(ctx, "k", 10*time.Millisecond); !errors.Is(err, ErrWaitTimeout) {
+		t.Errorf("WaitUntilAllowed with too short a wait = %v, want ErrWaitTimeout", err)
+	}
+	if err := rl.WaitUntilAllowed(ctx, "k", time.Second); err != nil {
+		t.Errorf("WaitUntilAllowed with time to wait = %v", err)
+	}
+}
+
+func TestMaxWaiters(t *testing.T) {
+	rl := New(time.Minute, 1, 1, WithMaxWaiters(1))
+	defer rl.Close()
+	rl.Limit("k")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- rl.Wait(ctx, "k") }()
+	for rl.waiters("k") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := rl.Wait(ctx, "k"); !errors.Is(err, ErrTooManyWaiters) {
+		t.Errorf("second waiter got %v, want ErrTooManyWaiters", err)
+	}
+	cancel()
+	<-done
+}
+
+// waiters returns the number of callers waiting on k's entry.
+func (rl *KeyedRateLimiter[K]) waiters(k K) int {
+	sh := rl.shardFor(k)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if v, ok := sh.entries[k]; ok {
+		return v.waiters
+	}
+	return 0
+}